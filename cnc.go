@@ -0,0 +1,60 @@
+package snappy
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// cncTool returns the attached tool head, confirming it is a CNC
+// tool head.
+func (c *Conn) cncTool() (ToolHead, error) {
+	th, err := c.Tool()
+	if err != nil {
+		return ToolHead{}, err
+	}
+	if th.Kind != ToolCNC {
+		return ToolHead{}, fmt.Errorf("tool head %q is not a CNC tool head: %w", th.Name, ErrInvalid)
+	}
+	return th, nil
+}
+
+// SpindleOn starts the spindle of the attached CNC tool head at rpm,
+// turning clockwise (M3) if cw is true and counter-clockwise (M4)
+// otherwise. The rpm must be within the ToolHead.SpindleMin and
+// SpindleMax range of the tool head. A head that only turns
+// SpindleClockwise, such as the 50W head, refuses counter-clockwise
+// rotation, and one with SpindlePercent is given its speed as a
+// percentage of SpindleMax.
+func (c *Conn) SpindleOn(ctx context.Context, rpm int, cw bool) error {
+	th, err := c.cncTool()
+	if err != nil {
+		return err
+	}
+	if rpm < th.SpindleMin || rpm > th.SpindleMax {
+		return fmt.Errorf("spindle speed %d outside %d..%d rpm: %w", rpm, th.SpindleMin, th.SpindleMax, ErrInvalid)
+	}
+	var code string
+	switch {
+	case th.SpindleClockwise && !cw:
+		return fmt.Errorf("tool head %q only turns clockwise: %w", th.Name, ErrInvalid)
+	case th.SpindlePercent:
+		code = fmt.Sprintf("P%d", int(math.Round(100*float64(rpm)/float64(th.SpindleMax))))
+	default:
+		code = fmt.Sprintf("S%d", rpm)
+	}
+	if cw {
+		code = "M3 " + code
+	} else {
+		code = "M4 " + code
+	}
+	return c.doCode(ctx, code)
+}
+
+// SpindleOff stops the spindle of the attached CNC tool head.
+func (c *Conn) SpindleOff(ctx context.Context) error {
+	if _, err := c.cncTool(); err != nil {
+		return err
+	}
+	return c.doCode(ctx, "M5")
+}
@@ -0,0 +1,76 @@
+package snappy_test
+
+import (
+	"errors"
+	"testing"
+
+	"zappem.net/pub/net/snappy"
+)
+
+func TestSpindle(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+	if err := c.SpindleOn(ctx, 10000, true); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("laser SpindleOn got %v want %v", err, snappy.ErrInvalid)
+	}
+
+	s.SetToolHead(1)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if th, err := c.Tool(); err != nil || !th.SpindleClockwise || !th.SpindlePercent {
+		t.Errorf("50W Tool got %+v, %v", th, err)
+	}
+	for _, bad := range []int{5999, 12001} {
+		if err := c.SpindleOn(ctx, bad, true); !errors.Is(err, snappy.ErrInvalid) {
+			t.Errorf("50W SpindleOn(%d) got %v", bad, err)
+		}
+	}
+	if err := c.SpindleOn(ctx, 9000, false); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("50W counter-clockwise SpindleOn got %v", err)
+	}
+	if err := c.SpindleOn(ctx, 9000, true); err != nil {
+		t.Fatalf("50W SpindleOn failed: %v", err)
+	}
+	if rpm, cw := s.Spindle(); rpm != 9000 || !cw {
+		t.Errorf("50W spindle got %d,%v want 9000,true", rpm, cw)
+	}
+	if codes := s.Codes(); codes[len(codes)-1] != "M3 P75" {
+		t.Errorf("50W SpindleOn sent %q", codes[len(codes)-1])
+	}
+	if err := c.SpindleOff(ctx); err != nil {
+		t.Fatalf("SpindleOff failed: %v", err)
+	}
+	if rpm, _ := s.Spindle(); rpm != 0 {
+		t.Errorf("spindle still at %d rpm", rpm)
+	}
+
+	s.SetToolHead(15)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if err := c.SpindleOn(ctx, 6000, true); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("200W SpindleOn(6000) got %v", err)
+	}
+	if err := c.SpindleOn(ctx, 16000, false); err != nil {
+		t.Fatalf("200W SpindleOn failed: %v", err)
+	}
+	if codes := s.Codes(); codes[len(codes)-1] != "M4 S16000" {
+		t.Errorf("200W SpindleOn sent %q", codes[len(codes)-1])
+	}
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	var found bool
+	for _, m := range c.ModuleInfo().ModuleInfo {
+		if mc, ok := m.Module.(*snappy.ModuleCNC200W); ok {
+			found = true
+			if mc.SpindleSpeed != 16000 || mc.SpindleClockwise {
+				t.Errorf("200W status got %+v", mc)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no 200W details in %v", c.ModuleInfo().ModuleInfo)
+	}
+}
@@ -0,0 +1,260 @@
+// Package config holds named profiles of Snapmaker machines, with
+// their connection details and calibrations, and loads and saves
+// them as a JSON file.
+//
+// A file written for a single machine, with top level Address,
+// Token and Tools values, is loaded as a profile named "default".
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"zappem.net/pub/net/snappy"
+)
+
+// EnvMachine is the environment variable naming the profile used
+// when none is given to Config.Select.
+const EnvMachine = "SNAPPY_MACHINE"
+
+// DefaultName is the name of the profile loaded from a single
+// machine file.
+const DefaultName = "default"
+
+// ErrNotFound is returned, wrapped with the profile name, when a
+// profile does not exist.
+var ErrNotFound = errors.New("machine profile not found")
+
+// Tool holds the calibration of a tool head of a machine.
+type Tool struct {
+	// CameraOffset, if set, holds the (dx,dy,dz) offset from the
+	// tool head location at which the camera takes an in-focus
+	// centered photo of that location.
+	CameraOffset []float64 `json:"CameraCoordsDelta,omitempty"`
+
+	// FocusHeight is the height, in mm, of the tool head above the
+	// work surface at which a laser is in focus.
+	FocusHeight float64 `json:",omitempty"`
+
+	// Park, if set, holds the machine coordinates at which to park
+	// this tool head for changing it, overriding the Park location
+	// of the MachineModel.
+	Park []float64 `json:",omitempty"`
+}
+
+// Profile holds the configuration of one machine.
+type Profile struct {
+	Address string
+	Token   string
+
+	// Model, if set, is the MachineModel.Name of the machine.
+	// Connect refuses to drive a machine of a different model.
+	Model string `json:",omitempty"`
+
+	// Tools holds the calibrations of the tool heads indexed by
+	// their ToolHead.ID.
+	Tools map[int]Tool `json:",omitempty"`
+
+	// SafeZ, if non-zero, is the work coordinate height at which
+	// absolute moves travel. Otherwise it is the clearance of the
+	// tool head. See snappy.Conn.SafeZ.
+	SafeZ float64 `json:",omitempty"`
+
+	// KeepOut holds machine coordinate regions the tool head
+	// must not be moved through, and MinZ the lowest machine Z
+	// coordinate it may be moved to.
+	KeepOut []snappy.Box `json:",omitempty"`
+	MinZ    float64      `json:",omitempty"`
+}
+
+// Validate confirms the values of p are well formed.
+func (p Profile) Validate() error {
+	if p.Address == "" {
+		return fmt.Errorf("no address: %w", snappy.ErrInvalid)
+	}
+	if p.Model != "" {
+		if _, err := snappy.ModelByName(p.Model); err != nil {
+			return fmt.Errorf("%v: %w", err, snappy.ErrInvalid)
+		}
+	}
+	for id, t := range p.Tools {
+		if n := len(t.CameraOffset); n != 0 && n != 3 {
+			return fmt.Errorf("tool %d camera offset has %d values, not 3: %w", id, n, snappy.ErrInvalid)
+		}
+		if n := len(t.Park); n != 0 && n != 3 {
+			return fmt.Errorf("tool %d park location has %d values, not 3: %w", id, n, snappy.ErrInvalid)
+		}
+		if t.FocusHeight < 0 {
+			return fmt.Errorf("tool %d focus height %g is negative: %w", id, t.FocusHeight, snappy.ErrInvalid)
+		}
+	}
+	return snappy.Limits{KeepOut: p.KeepOut}.Validate()
+}
+
+// Limits returns the limits of the model of machine, restricted by
+// the KeepOut and MinZ values of p.
+func (p Profile) Limits(m snappy.MachineModel) snappy.Limits {
+	l := m.Limits()
+	l.KeepOut, l.MinZ = p.KeepOut, p.MinZ
+	return l
+}
+
+// Connect connects to the machine of p, confirming it is of the
+// expected Model, and applies the limits and safe height of p.
+func (p Profile) Connect(ctx context.Context) (*snappy.Conn, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	c, err := snappy.NewConn(ctx, p.Address, p.Token)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Apply(c); err != nil {
+		c.CloseContext(ctx)
+		return nil, err
+	}
+	return c, nil
+}
+
+// Apply applies the limits and safe height of p to c, confirming c
+// is connected to a machine of the expected Model.
+func (p Profile) Apply(c *snappy.Conn) error {
+	m := c.Model()
+	if p.Model != "" && p.Model != m.Name {
+		return fmt.Errorf("connected to model %s, not the profile's %s: %w", m.Name, p.Model, snappy.ErrInvalid)
+	}
+	if err := c.SetLimits(p.Limits(m)); err != nil {
+		return err
+	}
+	c.SetSafeZ(p.SafeZ)
+	return nil
+}
+
+// Config holds the named profiles of a configuration file.
+type Config struct {
+	// Default is the name of the profile selected when no other
+	// is requested.
+	Default string `json:",omitempty"`
+
+	// Machines holds the profiles by name.
+	Machines map[string]Profile
+}
+
+// legacy is the format of a file holding a single machine.
+type legacy struct {
+	Config
+	Profile
+}
+
+// Parse decodes a configuration file. A single machine file is
+// returned as a profile named DefaultName.
+func Parse(data []byte) (*Config, error) {
+	var l legacy
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, err
+	}
+	conf := &l.Config
+	if conf.Machines == nil && (l.Address != "" || l.Token != "") {
+		conf.Default = DefaultName
+		conf.Machines = map[string]Profile{DefaultName: l.Profile}
+	}
+	if conf.Machines == nil {
+		conf.Machines = make(map[string]Profile)
+	}
+	return conf, nil
+}
+
+// Load reads the configuration file at path. If the file does not
+// exist, the returned error wraps os.ErrNotExist.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %v", path, err)
+	}
+	return conf, nil
+}
+
+// Save writes the configuration to path, readable only by its owner
+// since it holds tokens.
+func (conf *Config) Save(path string) error {
+	b, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0600)
+}
+
+// Names returns the sorted names of the profiles.
+func (conf *Config) Names() []string {
+	var names []string
+	for name := range conf.Machines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select returns the profile with name. An empty name selects the
+// profile named by the EnvMachine environment variable, then the
+// Default profile and finally the only profile, if there is just
+// one.
+func (conf *Config) Select(name string) (string, Profile, error) {
+	if name == "" {
+		name = os.Getenv(EnvMachine)
+	}
+	if name == "" {
+		name = conf.Default
+	}
+	if name == "" {
+		names := conf.Names()
+		if len(names) != 1 {
+			return "", Profile{}, fmt.Errorf("%d profiles, select one of %q: %w", len(names), names, ErrNotFound)
+		}
+		name = names[0]
+	}
+	p, ok := conf.Machines[name]
+	if !ok {
+		return name, p, fmt.Errorf("%q: %w", name, ErrNotFound)
+	}
+	return name, p, nil
+}
+
+// Set adds or replaces the profile with name. The first profile
+// added becomes the Default.
+func (conf *Config) Set(name string, p Profile) error {
+	if name == "" {
+		return fmt.Errorf("no profile name: %w", snappy.ErrInvalid)
+	}
+	if err := p.Validate(); err != nil {
+		return fmt.Errorf("profile %q: %w", name, err)
+	}
+	if conf.Machines == nil {
+		conf.Machines = make(map[string]Profile)
+	}
+	conf.Machines[name] = p
+	if conf.Default == "" {
+		conf.Default = name
+	}
+	return nil
+}
+
+// Remove removes the profile with name. If it was the Default, there
+// is no longer a Default.
+func (conf *Config) Remove(name string) error {
+	if _, ok := conf.Machines[name]; !ok {
+		return fmt.Errorf("%q: %w", name, ErrNotFound)
+	}
+	delete(conf.Machines, name)
+	if conf.Default == name {
+		conf.Default = ""
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"zappem.net/pub/net/snappy"
+	"zappem.net/pub/net/snappy/config"
+	"zappem.net/pub/net/snappy/snappytest"
+)
+
+func TestLoadLegacy(t *testing.T) {
+	conf, err := config.Load(filepath.Join("testdata", "legacy.json"))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := conf.Names(); !reflect.DeepEqual(got, []string{config.DefaultName}) {
+		t.Fatalf("got names %q, want [%q]", got, config.DefaultName)
+	}
+	name, p, err := conf.Select("")
+	if err != nil || name != config.DefaultName {
+		t.Fatalf("got Select()=%q,%v want %q", name, err, config.DefaultName)
+	}
+	want := config.Profile{
+		Address: "192.168.1.10",
+		Token:   "0123-4567",
+		Tools:   map[int]config.Tool{2: {CameraOffset: []float64{-20.5, 31, 0}}},
+		KeepOut: []snappy.Box{{Min: [3]float64{100, 100, 0}, Max: [3]float64{200, 200, 40}}},
+		MinZ:    5,
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %#v, want %#v", p, want)
+	}
+	if _, err := config.Load(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	conf, err := config.Load(filepath.Join("testdata", "profiles.json"))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := conf.Names(); !reflect.DeepEqual(got, []string{"a350", "garage", "shop"}) {
+		t.Errorf("got names %q", got)
+	}
+	if p := conf.Machines["a350"]; p.Tools[2].FocusHeight != 5.6 || p.Tools[14].Park[2] != 140 || p.SafeZ != 40 {
+		t.Errorf("a350 profile decoded as %#v", p)
+	}
+
+	t.Setenv(config.EnvMachine, "")
+	for _, v := range []struct {
+		name, env, want string
+		err             error
+	}{
+		{want: "a350"},
+		{env: "shop", want: "shop"},
+		{name: "garage", env: "shop", want: "garage"},
+		{name: "lab", err: config.ErrNotFound},
+		{env: "lab", err: config.ErrNotFound},
+	} {
+		t.Setenv(config.EnvMachine, v.env)
+		name, _, err := conf.Select(v.name)
+		if !errors.Is(err, v.err) || (v.err == nil && name != v.want) {
+			t.Errorf("Select(%q) with %s=%q got %q,%v want %q,%v", v.name, config.EnvMachine, v.env, name, err, v.want, v.err)
+		}
+	}
+
+	t.Setenv(config.EnvMachine, "")
+	conf.Default = ""
+	if _, _, err := conf.Select(""); !errors.Is(err, config.ErrNotFound) {
+		t.Errorf("Select with several profiles and no default got %v", err)
+	}
+}
+
+func TestSetRemove(t *testing.T) {
+	t.Setenv(config.EnvMachine, "")
+	path := filepath.Join(t.TempDir(), "snapmaker.config")
+	conf := &config.Config{}
+	if err := conf.Set("a350", config.Profile{Model: "A350"}); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("Set without an address got %v", err)
+	}
+	if err := conf.Set("a350", config.Profile{Address: "192.168.1.10", Model: "A999"}); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("Set with an unknown model got %v", err)
+	}
+	bad := config.Profile{Address: "192.168.1.10", Tools: map[int]config.Tool{2: {CameraOffset: []float64{1, 2}}}}
+	if err := conf.Set("a350", bad); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("Set with a short camera offset got %v", err)
+	}
+	for _, name := range []string{"a350", "shop"} {
+		if err := conf.Set(name, config.Profile{Address: name + ".local", Token: "t"}); err != nil {
+			t.Fatalf("Set(%q) failed: %v", name, err)
+		}
+	}
+	if conf.Default != "a350" {
+		t.Errorf("got default %q, want the first profile added", conf.Default)
+	}
+	if err := conf.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("saved file mode %v (%v), want 0600", fi.Mode(), err)
+	}
+	loaded, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, conf) {
+		t.Errorf("loaded %#v, saved %#v", loaded, conf)
+	}
+
+	if err := loaded.Remove("a350"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := loaded.Remove("a350"); !errors.Is(err, config.ErrNotFound) {
+		t.Errorf("second Remove got %v, want %v", err, config.ErrNotFound)
+	}
+	if loaded.Default != "" {
+		t.Errorf("removed default still the default: %q", loaded.Default)
+	}
+	if name, _, err := loaded.Select(""); err != nil || name != "shop" {
+		t.Errorf("got Select()=%q,%v, want the only profile", name, err)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := snappytest.NewServer("0123-4567")
+	defer s.Close()
+
+	p := config.Profile{
+		Address: s.Addr(),
+		Token:   "0123-4567",
+		Model:   "A250",
+	}
+	if _, err := p.Connect(ctx); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("connecting to an A350 as an A250 got %v", err)
+	}
+
+	p.Model = "A350"
+	p.SafeZ = 42
+	p.MinZ = 3
+	c, err := p.Connect(ctx)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Close()
+	if z := c.SafeZ(); z != 42 {
+		t.Errorf("got SafeZ()=%g, want 42", z)
+	}
+	// The profile height holds whichever tool head is attached.
+	s.SetToolHead(1)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if z := c.SafeZ(); z != 42 {
+		t.Errorf("got SafeZ()=%g after a tool head change, want 42", z)
+	}
+	if l := c.Limits(); l.MinZ != 3 || l.Travel != c.Model().Limits().Travel {
+		t.Errorf("got limits %#v", l)
+	}
+}
@@ -0,0 +1,122 @@
+package snappy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DiscoverPort is the UDP port on which Snapmaker machines listen
+// for discovery requests.
+const DiscoverPort = 20054
+
+// Machine describes a Snapmaker machine found on the LAN.
+type Machine struct {
+	// Name is the name the machine gives itself.
+	Name string
+	// Address is the IP address of the machine. It can be used
+	// as the ip argument of NewConn.
+	Address string
+	// Model is the reported model, for example "Snapmaker 2 Model A350".
+	Model string
+	// Status is the reported machine status, for example "IDLE".
+	Status string
+	// Fields holds all of the key:value fields of the reply.
+	Fields map[string]string
+}
+
+// parseDiscoverReply parses a discovery reply of the form
+// "name@ip|model:...|status:...". If the reply omits the IP address,
+// from is used.
+func parseDiscoverReply(reply string, from net.Addr) (Machine, error) {
+	parts := strings.Split(strings.TrimSpace(reply), "|")
+	name, ip, found := strings.Cut(parts[0], "@")
+	if !found || name == "" {
+		return Machine{}, fmt.Errorf("invalid discovery reply %q", reply)
+	}
+	if ip == "" {
+		if ua, ok := from.(*net.UDPAddr); ok {
+			ip = ua.IP.String()
+		}
+	}
+	m := Machine{
+		Name:    name,
+		Address: ip,
+		Fields:  make(map[string]string),
+	}
+	for _, p := range parts[1:] {
+		k, v, _ := strings.Cut(p, ":")
+		m.Fields[k] = v
+	}
+	m.Model = m.Fields["model"]
+	m.Status = m.Fields["status"]
+	return m, nil
+}
+
+// Discover broadcasts a discovery request on the LAN, in the same
+// way Luban does, and returns the machines that reply within
+// timeout.
+func Discover(ctx context.Context, timeout time.Duration) ([]Machine, error) {
+	return DiscoverAt(ctx, fmt.Sprint("255.255.255.255:", DiscoverPort), timeout)
+}
+
+// DiscoverAt sends a discovery request to the UDP addr and returns
+// the machines that reply within timeout. The addr is typically a
+// broadcast address.
+func DiscoverAt(ctx context.Context, addr string, timeout time.Duration) ([]Machine, error) {
+	to, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	if _, err := conn.WriteTo([]byte("discover"), to); err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]Machine)
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return nil, err
+		}
+		m, err := parseDiscoverReply(string(buf[:n]), from)
+		if err != nil {
+			continue
+		}
+		found[m.Address] = m
+	}
+	if ctx.Err() != nil {
+		return nil, ErrCanceled
+	}
+	var ms []Machine
+	for _, m := range found {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].Name != ms[j].Name {
+			return ms[i].Name < ms[j].Name
+		}
+		return ms[i].Address < ms[j].Address
+	})
+	return ms, nil
+}
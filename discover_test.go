@@ -0,0 +1,35 @@
+package snappy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+	"zappem.net/pub/net/snappy/snappytest"
+)
+
+func TestDiscover(t *testing.T) {
+	r, err := snappytest.NewResponder("Snapmaker-DIY", "Snapmaker 2 Model A350")
+	if err != nil {
+		t.Fatalf("NewResponder failed: %v", err)
+	}
+	defer r.Close()
+	ms, err := snappy.DiscoverAt(context.Background(), r.Addr(), 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("DiscoverAt failed: %v", err)
+	}
+	if len(ms) != 1 {
+		t.Fatalf("got %d machines, want 1: %#v", len(ms), ms)
+	}
+	m := ms[0]
+	if m.Name != "Snapmaker-DIY" || m.Address != "127.0.0.1" || m.Model != "Snapmaker 2 Model A350" || m.Status != "IDLE" {
+		t.Errorf("got %#v", m)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := snappy.DiscoverAt(ctx, r.Addr(), time.Minute); err != snappy.ErrCanceled {
+		t.Errorf("canceled DiscoverAt got %v want %v", err, snappy.ErrCanceled)
+	}
+}
@@ -0,0 +1,244 @@
+package snappy
+
+import (
+	"context"
+	"maps"
+	"time"
+)
+
+// EventKind identifies the type of change a StatusEvent describes.
+type EventKind int
+
+// EventStatus etc are the kinds of StatusEvent.
+const (
+	// EventStatus indicates the machine status changed, for
+	// example from IDLE to RUNNING.
+	EventStatus EventKind = iota
+	// EventPosition indicates the tool head location or the work
+	// origin offsets changed.
+	EventPosition
+	// EventTemperature indicates a nozzle or heated bed
+	// temperature, or target temperature, changed.
+	EventTemperature
+	// EventDoor indicates the enclosure door opened or closed.
+	EventDoor
+	// EventEmergencyStop indicates the emergency stop button was
+	// pressed or released.
+	EventEmergencyStop
+	// EventModule indicates a module was plugged or unplugged.
+	EventModule
+	// EventProgress indicates a running job made progress.
+	EventProgress
+	// EventConnection indicates the connection state changed.
+	EventConnection
+)
+
+// String returns a name for the event kind.
+func (k EventKind) String() string {
+	switch k {
+	case EventStatus:
+		return "status"
+	case EventPosition:
+		return "position"
+	case EventTemperature:
+		return "temperature"
+	case EventDoor:
+		return "door"
+	case EventEmergencyStop:
+		return "emergency-stop"
+	case EventModule:
+		return "module"
+	case EventProgress:
+		return "progress"
+	case EventConnection:
+		return "connection"
+	default:
+		return "unknown"
+	}
+}
+
+// StatusEvent describes a change observed by a status update.
+type StatusEvent struct {
+	Kind EventKind
+	Time time.Time
+
+	// Status holds the full status at the time of the event.
+	Status StatusResult
+
+	// From and To hold the old and new machine status of an
+	// EventStatus.
+	From, To MachineState
+
+	// Module and Present identify the module of an EventModule
+	// and whether it is now plugged in.
+	Module  string
+	Present bool
+
+	// EmergencyStopped is the state of the emergency stop button
+	// for an EventEmergencyStop.
+	EmergencyStopped bool
+
+	// Connection is the new connection state of an
+	// EventConnection.
+	Connection ConnState
+
+	// Dropped counts the events not delivered to this subscriber,
+	// since the previous delivered event, because it was not
+	// keeping up.
+	Dropped uint64
+}
+
+// eventState holds the values used to detect events.
+type eventState struct {
+	status  StatusResult
+	estop   bool
+	hasStop bool
+}
+
+// subscriber holds the delivery state of a single subscription.
+type subscriber struct {
+	ch      chan StatusEvent
+	dropped uint64
+}
+
+// subscriberDepth is the number of events buffered per subscriber.
+const subscriberDepth = 32
+
+// emergencyStopped reports the state of an emergency stop module, if
+// one is attached. The caller holds c.mu.
+func (c *Conn) emergencyStopped() (stopped, present bool) {
+	for _, m := range c.modState.ModuleInfo {
+		if es, ok := m.Module.(*ModuleEmergencyStop); ok {
+			return es.IsEmergencyStopped, true
+		}
+	}
+	return false, false
+}
+
+// snapshot captures the current event state. The caller holds c.mu.
+func (c *Conn) snapshot() *eventState {
+	es := &eventState{status: c.toolState}
+	es.status.ModuleList = maps.Clone(c.toolState.ModuleList)
+	es.estop, es.hasStop = c.emergencyStopped()
+	return es
+}
+
+// changes computes the events that distinguish now from was.
+func changes(was, now *eventState, when time.Time) (evs []StatusEvent) {
+	add := func(ev StatusEvent) {
+		ev.Time = when
+		ev.Status = now.status
+		evs = append(evs, ev)
+	}
+	a, b := &was.status, &now.status
+	if a.Status != b.Status {
+		add(StatusEvent{Kind: EventStatus, From: a.State(), To: b.State()})
+	}
+	if a.X != b.X || a.Y != b.Y || a.Z != b.Z || a.OffsetX != b.OffsetX || a.OffsetY != b.OffsetY || a.OffsetZ != b.OffsetZ {
+		add(StatusEvent{Kind: EventPosition})
+	}
+	if a.NozzleTemperature != b.NozzleTemperature || a.NozzleTargetTemperature != b.NozzleTargetTemperature ||
+		a.NozzleTemperature1 != b.NozzleTemperature1 || a.NozzleTargetTemperature1 != b.NozzleTargetTemperature1 ||
+		a.NozzleTemperature2 != b.NozzleTemperature2 || a.NozzleTargetTemperature2 != b.NozzleTargetTemperature2 ||
+		a.HeatedBedTemperature != b.HeatedBedTemperature || a.HeatedBedTargetTemperature != b.HeatedBedTargetTemperature {
+		add(StatusEvent{Kind: EventTemperature})
+	}
+	if a.IsEnclosureDoorOpen != b.IsEnclosureDoorOpen {
+		add(StatusEvent{Kind: EventDoor})
+	}
+	if now.hasStop && (!was.hasStop || was.estop != now.estop) {
+		add(StatusEvent{Kind: EventEmergencyStop, EmergencyStopped: now.estop})
+	}
+	for name, present := range b.ModuleList {
+		if old, ok := a.ModuleList[name]; !ok || old != present {
+			add(StatusEvent{Kind: EventModule, Module: name, Present: present})
+		}
+	}
+	for name, present := range a.ModuleList {
+		if _, ok := b.ModuleList[name]; !ok && present {
+			add(StatusEvent{Kind: EventModule, Module: name})
+		}
+	}
+	if b.TotalLines != 0 && (a.CurrentLine != b.CurrentLine || a.Progress != b.Progress || a.TotalLines != b.TotalLines) {
+		add(StatusEvent{Kind: EventProgress})
+	}
+	return
+}
+
+// publishChanges notifies all subscribers of the changes since the
+// last status update. The caller holds c.mu.
+func (c *Conn) publishChanges() {
+	now := c.snapshot()
+	was := c.seen
+	c.seen = now
+	if was == nil {
+		return
+	}
+	c.publish(changes(was, now, c.lastUpdated))
+}
+
+// publish delivers events to all subscribers. The caller holds c.mu.
+func (c *Conn) publish(evs []StatusEvent) {
+	for _, ev := range evs {
+		for sub := range c.subs {
+			ev.Dropped = sub.dropped
+			select {
+			case sub.ch <- ev:
+				sub.dropped = 0
+			default:
+				sub.dropped++
+				c.dropped++
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel over which the events observed by
+// subsequent status updates are delivered. Delivery never blocks
+// status polling: events that do not fit in the channel buffer are
+// dropped and counted (see StatusEvent.Dropped). The channel is
+// closed when ctx is canceled or the connection is closed.
+func (c *Conn) Subscribe(ctx context.Context) <-chan StatusEvent {
+	sub := &subscriber{ch: make(chan StatusEvent, subscriberDepth)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		close(sub.ch)
+		return sub.ch
+	}
+	if c.subs == nil {
+		c.subs = make(map[*subscriber]bool)
+	}
+	c.subs[sub] = true
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.closed:
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.subs[sub] {
+			delete(c.subs, sub)
+			close(sub.ch)
+		}
+	}()
+	return sub.ch
+}
+
+// unsubscribeAll closes all subscriber channels. The caller holds
+// c.mu.
+func (c *Conn) unsubscribeAll() {
+	for sub := range c.subs {
+		close(sub.ch)
+	}
+	c.subs = nil
+}
+
+// DroppedEvents returns the total number of events, across all
+// subscribers, that were dropped because a subscriber was not
+// keeping up.
+func (c *Conn) DroppedEvents() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
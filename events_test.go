@@ -0,0 +1,131 @@
+package snappy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+	"zappem.net/pub/net/snappy/snappytest"
+)
+
+// nextEvent returns the next event of kind, skipping others.
+func nextEvent(t *testing.T, ch <-chan snappy.StatusEvent, kind snappy.EventKind) snappy.StatusEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				t.Fatalf("channel closed waiting for %v event", kind)
+			}
+			if ev.Kind == kind {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v event", kind)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+	ch := c.Subscribe(ctx)
+
+	s.Update(func(st *snappy.StatusResult) {
+		st.IsEnclosureDoorOpen = true
+		st.HeatedBedTemperature = 42
+	})
+	s.SetEmergencyStop(true)
+	if err := c.MoveTo(ctx, 1, 2, 3); err != nil {
+		t.Fatalf("MoveTo failed: %v", err)
+	}
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if ev := nextEvent(t, ch, snappy.EventPosition); ev.Status.X != 1 || ev.Status.Y != 2 || ev.Status.Z != 3 {
+		t.Errorf("position event at (%g,%g,%g)", ev.Status.X, ev.Status.Y, ev.Status.Z)
+	}
+	if ev := nextEvent(t, ch, snappy.EventTemperature); ev.Status.HeatedBedTemperature != 42 {
+		t.Errorf("bed temperature event got %g", ev.Status.HeatedBedTemperature)
+	}
+	if ev := nextEvent(t, ch, snappy.EventDoor); !ev.Status.IsEnclosureDoorOpen {
+		t.Error("door event with closed door")
+	}
+	if ev := nextEvent(t, ch, snappy.EventEmergencyStop); !ev.EmergencyStopped {
+		t.Error("emergency stop event not stopped")
+	}
+	if ev := nextEvent(t, ch, snappy.EventModule); ev.Module != "emergencyStopButton" || !ev.Present {
+		t.Errorf("module event got %q present=%v", ev.Module, ev.Present)
+	}
+
+	if err := c.RunProgram("job.nc", []byte("G0 X1\nG0 X2\n")); err != nil {
+		t.Fatalf("RunProgram failed: %v", err)
+	}
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if ev := nextEvent(t, ch, snappy.EventStatus); ev.From != snappy.StateIdle || ev.To != snappy.StateRunning {
+		t.Errorf("status event %v -> %v", ev.From, ev.To)
+	}
+	s.Advance(1)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	for {
+		ev := nextEvent(t, ch, snappy.EventProgress)
+		if ev.Status.CurrentLine == 1 {
+			break
+		}
+		if ev.Status.CurrentLine != 0 {
+			t.Fatalf("progress event at line %d", ev.Status.CurrentLine)
+		}
+	}
+
+	c.Close()
+	for range ch {
+	}
+}
+
+func TestSubscribeDropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := snappytest.NewServer(testToken)
+	defer s.Close()
+	c, err := snappy.NewConnWithOptions(ctx, snappy.Options{
+		BaseURL:      s.URL,
+		Token:        testToken,
+		PollInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewConnWithOptions failed: %v", err)
+	}
+	defer c.Close()
+	sctx, scancel := context.WithCancel(ctx)
+	ch := c.Subscribe(sctx)
+	for i := 0; i < 100; i++ {
+		s.Update(func(st *snappy.StatusResult) { st.X = float64(i) })
+		if err := c.Status(); err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+	}
+	if n := c.DroppedEvents(); n == 0 {
+		t.Error("no events dropped")
+	}
+	var dropped uint64
+	for len(ch) != 0 {
+		dropped += (<-ch).Dropped
+	}
+	s.Update(func(st *snappy.StatusResult) { st.X = -1 })
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	dropped += (<-ch).Dropped
+	if dropped != c.DroppedEvents() {
+		t.Errorf("subscriber saw %d dropped, want %d", dropped, c.DroppedEvents())
+	}
+	scancel()
+	for range ch {
+	}
+}
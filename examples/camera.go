@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"zappem.net/pub/graphics/raster"
+	"zappem.net/pub/net/snappy"
+)
+
+// markUp overlays some targeting lines on an image.
+func markUp(jp []byte) (draw.Image, error) {
+	buf := bytes.NewBuffer(jp)
+	im, format, err := image.Decode(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %q image: %v", format, err)
+	}
+	bb := im.Bounds()
+	w := bb.Max.X - bb.Min.X
+	if h := bb.Max.Y - bb.Min.Y; h < w {
+		w = h
+	}
+	r := .1 * float64(w)
+	pen := raster.NewRasterizer()
+	const delta = 3.0
+	const wide = 2.0
+	const base = 4.0
+	const mag = 0.75
+	raster.LineTo(pen, false, base+0, base+0, base+r-delta, base+r-delta, wide)
+	raster.LineTo(pen, false, base+2*r, base+2*r, base+r+delta, base+r+delta, wide)
+	raster.LineTo(pen, false, base+2*r, base+0, base+r+delta, base+r-delta, wide)
+	raster.LineTo(pen, false, base+0, base+2*r, base+r-delta, base+r+delta, wide)
+	raster.LineTo(pen, false, base+r-r*mag, base+4*r/3, base+r-r*mag, base+2*r/3, wide/2)
+	raster.LineTo(pen, false, base+r+r*mag, base+4*r/3, base+r+r*mag, base+2*r/3, wide/2)
+	raster.LineTo(pen, false, base+4*r/3, base+r-r*mag, base+2*r/3, base+r-r*mag, wide/2)
+	raster.LineTo(pen, false, base+4*r/3, base+r+r*mag, base+2*r/3, base+r+r*mag, wide/2)
+	out := image.NewRGBA(bb)
+	draw.Draw(out, bb, im, image.ZP, draw.Src)
+	pen.Render(out, float64(bb.Min.X+bb.Max.X)/2-(r+base), float64(bb.Min.Y+bb.Max.Y)/2-(r+base), color.RGBA{255, 0, 255, 255})
+	return out, nil
+}
+
+// processJPEG marks up the photo d with targeting lines if marks is
+// set.
+func processJPEG(d []byte, marks bool) ([]byte, error) {
+	if !marks {
+		return d, nil
+	}
+	im, err := markUp(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark up the image: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, im, nil); err != nil {
+		return nil, fmt.Errorf("failed to encode jpeg: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// cameraReport is the result of the camera command.
+type cameraReport struct {
+	// Files holds the names of the photos taken.
+	Files []string `json:"files,omitempty"`
+
+	// ToolID and Offset are the tool head and its camera offset
+	// recorded by "camera offset".
+	ToolID int       `json:"toolId"`
+	Offset []float64 `json:"offset,omitempty"`
+}
+
+func cmdCamera(ctx context.Context, args []string) error {
+	f := newFlags("camera", "photo|snap|circle|zoom|offset", `Take photos with the tool head camera, saved as photo.jpg or, for a
+series, photo<n>.jpg:
+
+  photo   take a photo at the current location
+  snap    take a photo at the camera offset of the tool head, and return
+  circle  take a series of photos in a circle around --{x,y,z}
+  zoom    take a series of photos lowering by --zd from --{x,y,z}
+  offset  record --{x,y,z} as the camera offset of the tool head
+
+Where --x, --y or --z are not given, the current location is used
+for circle and zoom, and 0 for offset.`)
+	x := f.Float64("x", 0, "x coordinate (mm)")
+	y := f.Float64("y", 0, "y coordinate (mm)")
+	z := f.Float64("z", 0, "z coordinate (mm)")
+	zd := f.Float64("zd", 1, "zoom dz delta (mm) between photos")
+	marks := f.Bool("marks", false, "mark all photos with targeting lines")
+	mode := f.parse(args, 1, 1)[0]
+	if !slices.Contains([]string{"photo", "snap", "circle", "zoom", "offset"}, mode) {
+		f.Usage()
+		os.Exit(2)
+	}
+	set := make(map[string]bool)
+	f.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	c, t, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	toolID, _, err := c.ToolHead(1)
+	if err != nil {
+		return fmt.Errorf("failed to get key=1 detail: %v", err)
+	}
+	if th, err := snappy.ToolHeadByID(toolID); err != nil || !th.Camera {
+		return fmt.Errorf("toolID=%d(%q) has no supported camera", toolID, snappy.ModuleNames[toolID])
+	}
+	r := cameraReport{ToolID: toolID}
+
+	if mode == "offset" {
+		tool := t.tool(toolID)
+		tool.CameraOffset = []float64{*x, *y, *z}
+		t.setTool(toolID, tool)
+		if err := t.save(); err != nil {
+			return err
+		}
+		r.Offset = tool.CameraOffset
+		return f.report(r, fmt.Sprintf("machine %q updated with camera offset for toolID=%d(%q)", t.name, toolID, snappy.ModuleNames[toolID]))
+	}
+
+	if err := requireHomed(c); err != nil {
+		return err
+	}
+	save := func(name string, d []byte) error {
+		d, err := processJPEG(d, *marks)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, d, 0666); err != nil {
+			return fmt.Errorf("no photo: %v", err)
+		}
+		r.Files = append(r.Files, name)
+		return nil
+	}
+	cx, cy, cz, _, _, _ := c.CurrentLocation()
+	if set["x"] {
+		cx = *x
+	}
+	if set["y"] {
+		cy = *y
+	}
+	if set["z"] {
+		cz = *z
+	}
+
+	switch mode {
+	case "photo":
+		d, err := c.SnapJPEG(ctx, 0)
+		if err != nil {
+			return fmt.Errorf("photo grab failed: %v", err)
+		}
+		if err := save("photo.jpg", d); err != nil {
+			return err
+		}
+	case "snap":
+		dXYZ := t.tool(toolID).CameraOffset
+		if len(dXYZ) != 3 {
+			return fmt.Errorf("tool=%d(%q) camera offset unknown, use: snappy camera offset", toolID, snappy.ModuleNames[toolID])
+		}
+		hx, hy, hz, _, _, _ := c.CurrentLocation()
+		d, err := c.SnapAtJPEG(ctx, 0, hx+dXYZ[0], hy+dXYZ[1], hz+dXYZ[2])
+		if err != nil {
+			return fmt.Errorf("snap failed at (%g,%g,%g): %v", hx+dXYZ[0], hy+dXYZ[1], hz+dXYZ[2], err)
+		}
+		if err := save("photo.jpg", d); err != nil {
+			return err
+		}
+		if err := c.MoveTo(ctx, hx, hy, hz); err != nil {
+			return fmt.Errorf("return to (%.2f,%.2f,%.2f) failed: %v", hx, hy, hz, err)
+		}
+	case "circle":
+		for i := 0; i < 9; i++ {
+			theta := float64(i) / 9.0 * 2.0 * math.Pi
+			const radius = 15.0
+			log.Printf("taking photo %d (at %.2f deg)", i, theta/math.Pi*180)
+			d, err := c.SnapAtJPEG(ctx, i, cx+radius*math.Cos(theta), cy+radius*math.Sin(theta), cz)
+			if err != nil {
+				return fmt.Errorf("photo grab failed: %v", err)
+			}
+			if err := save(fmt.Sprintf("photo%d.jpg", i), d); err != nil {
+				return err
+			}
+		}
+	case "zoom":
+		for i := 0; i < 9; i++ {
+			height := cz - float64(i)**zd
+			log.Printf("taking photo %d (at %.2f mm)", i, height)
+			d, err := c.SnapAtJPEG(ctx, i, cx, cy, height)
+			if err != nil {
+				return fmt.Errorf("photo grab failed: %v", err)
+			}
+			if err := save(fmt.Sprintf("photo%d.jpg", i), d); err != nil {
+				return err
+			}
+		}
+	}
+	return f.report(r, strings.Join(r.Files, "\n"))
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+)
+
+// jobCommands lists the subcommands of the job command.
+var jobCommands = []command{
+	{"run", "upload and run a program", cmdJobRun},
+	{"upload", "upload a program without running it", cmdJobUpload},
+	{"start", "run the program last uploaded", cmdJobStart},
+	{"pause", "pause the running program", cmdJobPause},
+	{"resume", "resume the paused program", cmdJobResume},
+	{"stop", "stop the running program", cmdJobStop},
+	{"watch", "display the progress of the program until it completes", cmdJobWatch},
+	{"edit", "comment out lines of a program", cmdJobEdit},
+}
+
+func cmdJob(ctx context.Context, args []string) error {
+	return runSubcommand(ctx, "job", jobCommands, args)
+}
+
+// jobReport is the result of the job commands.
+type jobReport struct {
+	State         string  `json:"state"`
+	Job           string  `json:"job"`
+	File          string  `json:"file"`
+	Line          int     `json:"line"`
+	TotalLines    int     `json:"totalLines"`
+	Progress      float64 `json:"progress"`
+	ElapsedTime   int     `json:"elapsedTime"`
+	RemainingTime int     `json:"remainingTime"`
+}
+
+// newJobReport summarizes the program progress of st.
+func newJobReport(st snappy.StatusResult) jobReport {
+	return jobReport{
+		State:         st.Status,
+		Job:           st.PrintStatus,
+		File:          st.FileName,
+		Line:          st.CurrentLine,
+		TotalLines:    st.TotalLines,
+		Progress:      st.Progress,
+		ElapsedTime:   st.ElapsedTime,
+		RemainingTime: st.RemainingTime,
+	}
+}
+
+// reportJob reports the refreshed program progress of c as the result
+// of a command.
+func (f *flags) reportJob(ctx context.Context, c *snappy.Conn) error {
+	if err := c.StatusContext(ctx); err != nil {
+		return fmt.Errorf("failed to read status: %v", err)
+	}
+	_, status := c.Running()
+	return f.report(newJobReport(c.LastStatus()), status)
+}
+
+// jobConnect parses the flags of a job command that takes no
+// arguments and connects to the machine.
+func jobConnect(ctx context.Context, f *flags, args []string) (*snappy.Conn, error) {
+	f.parse(args, 0, 0)
+	c, _, err := connect(ctx)
+	return c, err
+}
+
+// grep performs a regexp match in an array of []byte lines.
+// Returns the number of the matching line (starts at 0), the
+// string content of that line or an error.
+func grep(lines [][]byte, val string) (int, string, error) {
+	re, err := regexp.Compile(val)
+	if err != nil {
+		return 0, "", err
+	}
+	for n, line := range lines {
+		if re.Match(line) {
+			return n, string(line), nil
+		}
+	}
+	return 0, "", fmt.Errorf("%q does not match", val)
+}
+
+// byteSize formats a number of bytes for display.
+func byteSize(n float64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", n/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", n/(1<<10))
+	}
+	return fmt.Sprintf("%.0f B", n)
+}
+
+// uploadProgress returns the options to draw a progress bar of an
+// upload on stderr, and a function to call when the upload is done.
+// With --json, no progress bar is drawn.
+func (f *flags) uploadProgress() ([]snappy.UploadOption, func()) {
+	if f.json {
+		return nil, func() {}
+	}
+	const width = 30
+	drawn := false
+	opt := snappy.WithProgress(200*time.Millisecond, func(p snappy.UploadProgress) {
+		drawn = true
+		rate := byteSize(p.Rate) + "/s"
+		if p.Total <= 0 {
+			fmt.Fprintf(os.Stderr, "\ruploaded %s at %s\033[0K", byteSize(float64(p.Sent)), rate)
+			return
+		}
+		done := min(width, int(width*p.Sent/p.Total))
+		bar := strings.Repeat("=", done) + strings.Repeat(" ", width-done)
+		fmt.Fprintf(os.Stderr, "\r[%s] %3d%% %s of %s at %s ETA %v\033[0K", bar, 100*p.Sent/p.Total, byteSize(float64(p.Sent)), byteSize(float64(p.Total)), rate, p.ETA.Round(time.Second))
+	})
+	return []snappy.UploadOption{opt}, func() {
+		if drawn {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+func cmdJobRun(ctx context.Context, args []string) error {
+	f := newFlags("job run", "<program>", "Upload the program to the machine and run it.")
+	watch := f.Bool("watch", false, "then display the progress of the program until it completes")
+	program := f.parse(args, 1, 1)[0]
+	data, err := os.ReadFile(program)
+	if err != nil {
+		return fmt.Errorf("unable to read %q: %v", program, err)
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	n, line, err := grep(lines, "^;estimated_time")
+	if err != nil {
+		log.Printf("no estimated time for completion [%d]: %v", n, err)
+	} else if val, err := strconv.ParseFloat(line[20:], 64); err != nil {
+		log.Printf("failed to parse line=%d %q: %v", n, line, err)
+	} else {
+		when := time.Now().Add(time.Microsecond * time.Duration(1e6*val))
+		log.Printf("ETA for completion from file: %s", when.Format(time.DateTime))
+	}
+
+	c, _, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	if err := requireHomed(c); err != nil {
+		return err
+	}
+	opts, done := f.uploadProgress()
+	err = c.RunProgramContext(ctx, program, data, opts...)
+	done()
+	if err != nil {
+		return fmt.Errorf("failed to upload and run %q: %v", program, err)
+	}
+	return startedJob(ctx, c, f, *watch)
+}
+
+// startedJob reports the program just started and, if watch is set,
+// displays its progress until it completes.
+func startedJob(ctx context.Context, c *snappy.Conn, f *flags, watch bool) error {
+	if !watch {
+		return f.reportJob(ctx, c)
+	}
+	log.Println("[waiting to start]")
+	if _, err := c.AwaitState(ctx, 0, snappy.StateRunning); err != nil {
+		return fmt.Errorf("waiting to start running failed: %v", err)
+	}
+	return watchJob(ctx, c, f)
+}
+
+func cmdJobUpload(ctx context.Context, args []string) error {
+	f := newFlags("job upload", "<program>", `Upload the program to the machine without running it. It replaces the
+program previously uploaded, and can be started from the touchscreen
+or with "snappy job start". The program file is streamed, not read
+into memory.`)
+	program := f.parse(args, 1, 1)[0]
+	file, err := os.Open(program)
+	if err != nil {
+		return fmt.Errorf("unable to read %q: %v", program, err)
+	}
+	defer file.Close()
+	c, _, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	opts, done := f.uploadProgress()
+	err = c.UploadProgramFrom(ctx, program, file, opts...)
+	done()
+	if err != nil {
+		return fmt.Errorf("failed to upload %q: %v", program, err)
+	}
+	return f.report(struct {
+		File string `json:"file"`
+	}{filepath.Base(program)}, fmt.Sprintf("uploaded %q", program))
+}
+
+func cmdJobStart(ctx context.Context, args []string) error {
+	f := newFlags("job start", "", "Run the program last uploaded to the machine, which can be run again\nonce it completes.")
+	watch := f.Bool("watch", false, "then display the progress of the program until it completes")
+	c, err := jobConnect(ctx, f, args)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	if err := requireHomed(c); err != nil {
+		return err
+	}
+	if err := c.StartPreparedProgram(ctx); err != nil {
+		return fmt.Errorf("failed to start: %v", err)
+	}
+	return startedJob(ctx, c, f, *watch)
+}
+
+// watchJob displays the progress of the running program until the
+// machine is idle. With --json each progress update is written as a
+// line of JSON.
+func watchJob(ctx context.Context, c *snappy.Conn, f *flags) error {
+	log.Println("[waiting for idle]")
+	events := c.Subscribe(ctx)
+	if ok, _ := c.Running(); ok {
+		polled := false
+		for ev := range events {
+			if ev.Kind == snappy.EventProgress {
+				if f.json {
+					if err := json.NewEncoder(os.Stdout).Encode(newJobReport(ev.Status)); err != nil {
+						return err
+					}
+				} else {
+					_, status := c.Running()
+					fmt.Printf("\r%s\033[0K", status)
+					polled = true
+				}
+			}
+			if ev.Kind == snappy.EventStatus && ev.To == snappy.StateIdle {
+				break
+			}
+		}
+		if polled {
+			fmt.Println()
+		}
+	}
+	if _, err := c.AwaitState(ctx, 0, snappy.StateIdle); err != nil {
+		return fmt.Errorf("waiting for idle failed: %v", err)
+	}
+	log.Println("[system is idle]")
+	return f.reportJob(ctx, c)
+}
+
+func cmdJobWatch(ctx context.Context, args []string) error {
+	f := newFlags("job watch", "", "Display the progress of the running program until it completes.")
+	c, err := jobConnect(ctx, f, args)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	return watchJob(ctx, c, f)
+}
+
+func cmdJobPause(ctx context.Context, args []string) error {
+	f := newFlags("job pause", "", "Pause the running program.")
+	c, err := jobConnect(ctx, f, args)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	if err := c.PauseProgramContext(ctx); err != nil {
+		return fmt.Errorf("failed to pause: %v", err)
+	}
+	return f.reportJob(ctx, c)
+}
+
+func cmdJobResume(ctx context.Context, args []string) error {
+	f := newFlags("job resume", "", "Resume the paused program.")
+	c, err := jobConnect(ctx, f, args)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	if err := c.ResumeProgramContext(ctx); err != nil {
+		return fmt.Errorf("failed to resume: %v", err)
+	}
+	return f.reportJob(ctx, c)
+}
+
+func cmdJobStop(ctx context.Context, args []string) error {
+	f := newFlags("job stop", "", "Stop the running program.")
+	c, err := jobConnect(ctx, f, args)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	if err := c.StopProgramContext(ctx); err != nil {
+		return fmt.Errorf("failed to stop: %v", err)
+	}
+	return f.reportJob(ctx, c)
+}
+
+func cmdJobEdit(ctx context.Context, args []string) error {
+	f := newFlags("job edit", "<program>", "Write a copy of the program, edited-<program>, with some of its\nlines commented out. The machine is not contacted.")
+	edit := f.String("lines", "", "comma separated sets of lines to comment out, <n> or <n>-<m>")
+	program := f.parse(args, 1, 1)[0]
+	if *edit == "" {
+		return errors.New("--lines is required")
+	}
+	data, err := os.ReadFile(program)
+	if err != nil {
+		return fmt.Errorf("unable to read %q: %v", program, err)
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	for _, sec := range strings.Split(*edit, ",") {
+		nums := strings.Split(sec, "-")
+		if len(nums) > 2 {
+			return fmt.Errorf("--lines requires <n> or <n>-<m> fields; invalid: %q", sec)
+		}
+		from, err := strconv.Atoi(nums[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse --lines=..%q..: %v", nums[0], err)
+		}
+		if from < 1 || from > len(lines) {
+			return fmt.Errorf("%q is out of bounds for %q (length=%d)", sec, program, len(lines))
+		}
+		to := from
+		if len(nums) == 2 {
+			to, err = strconv.Atoi(nums[1])
+			if err != nil {
+				if nums[1] != "" {
+					return fmt.Errorf("failed to parse 2nd number from --lines=..%q..: %v", sec, err)
+				}
+				to = len(lines)
+			}
+			if to < from {
+				return fmt.Errorf("--lines range is b>=a, not %q", sec)
+			}
+			if to > len(lines) {
+				return fmt.Errorf("--lines range beyond length of %q: %q vs %d", program, sec, len(lines))
+			}
+		}
+		for i := from - 1; i < to; i++ {
+			line := lines[i]
+			if len(line) == 0 {
+				continue
+			}
+			if bytes.HasPrefix(line, []byte(";")) {
+				continue
+			}
+			lines[i] = append([]byte(";"), lines[i]...)
+		}
+	}
+	replacement := bytes.Join(lines, []byte("\n"))
+	output := fmt.Sprint("edited-", filepath.Base(program))
+	if err := os.WriteFile(output, replacement, 0666); err != nil {
+		return fmt.Errorf("failed to write edited program %q: %v", output, err)
+	}
+	return f.report(struct {
+		File string `json:"file"`
+	}{output}, fmt.Sprintf("wrote %q", output))
+}
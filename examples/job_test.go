@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJobEdit(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	program := filepath.Join(dir, "job.nc")
+	if err := os.WriteFile(program, []byte("G0 X1\nG0 X2\nG0 X3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []string{"0", "0-3", "5", "2-1", "1-9", "x"} {
+		if err := cmdJobEdit(context.Background(), []string{"--lines", bad, program}); err == nil {
+			t.Errorf("--lines %s succeeded", bad)
+		}
+	}
+	if err := cmdJobEdit(context.Background(), []string{"--lines", "1,3-", program}); err != nil {
+		t.Fatalf("job edit failed: %v", err)
+	}
+	got, err := os.ReadFile("edited-job.nc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ";G0 X1\nG0 X2\n;G0 X3\n"; string(got) != want {
+		t.Errorf("got edited program %q want %q", got, want)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+)
+
+// jogSteps are the selectable jog step sizes in mm.
+var jogSteps = []float64{10, 1, 0.1, 0.05}
+
+// jogHelp describes the keys of the jog mode.
+const jogHelp = `jog mode keys:
+  left/right  step -X/+X      up/down  step +Y/-Y    PgUp/PgDn  step +Z/-Z
+  1 2 3 4     step size 10, 1, 0.1, 0.05 mm
+  o           set the work origin here
+  g           go to the work origin
+  c           toggle the laser cross-hairs
+  s           take a photo (photo.jpg)
+  q           quit`
+
+// Key names returned by readKeys for the multi-byte sequences of
+// special keys.
+const (
+	keyUp       = "up"
+	keyDown     = "down"
+	keyLeft     = "left"
+	keyRight    = "right"
+	keyPageUp   = "pgup"
+	keyPageDown = "pgdn"
+)
+
+// escapes maps the terminal escape sequences of special keys to
+// their names.
+var escapes = map[string]string{
+	"\x1b[A":  keyUp,
+	"\x1b[B":  keyDown,
+	"\x1b[C":  keyRight,
+	"\x1b[D":  keyLeft,
+	"\x1b[5~": keyPageUp,
+	"\x1b[6~": keyPageDown,
+}
+
+// rawTerminal puts the terminal of stdin into raw mode with stty,
+// returning a function to restore its previous mode. Reads of stdin
+// return after a tenth of a second with no key pressed, so that
+// readKeys can stop.
+func rawTerminal() (func(), error) {
+	stty := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		return cmd.Output()
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("stdin is not a terminal: %v", err)
+	}
+	if _, err := stty("raw", "-echo", "min", "0", "time", "1"); err != nil {
+		return nil, err
+	}
+	return func() { stty(strings.TrimSpace(string(saved))) }, nil
+}
+
+// readKeys reads key presses from r, sending each to keys as a
+// single character or one of the key names of escapes. Unrecognized
+// escape sequences are ignored. The keys channel is closed when ctx
+// is canceled or r fails. An io.EOF from r is a read of the raw
+// terminal timing out (see rawTerminal) with no key pressed.
+func readKeys(ctx context.Context, r io.Reader, keys chan<- string) {
+	defer close(keys)
+	send := func(key string) bool {
+		select {
+		case keys <- key:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	buf := make([]byte, 16)
+	for ctx.Err() == nil {
+		n, err := r.Read(buf)
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		for b := buf[:n]; len(b) > 0; {
+			if b[0] != 0x1b {
+				if !send(string(b[:1])) {
+					return
+				}
+				b = b[1:]
+				continue
+			}
+			// An escape sequence ends with a letter or '~'.
+			end := bytes.IndexFunc(b[1:], func(r rune) bool {
+				return r == '~' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z')
+			})
+			if end < 0 {
+				break
+			}
+			if name, ok := escapes[string(b[:end+2])]; ok && !send(name) {
+				return
+			}
+			b = b[end+2:]
+		}
+	}
+}
+
+// jog runs an interactive session moving the tool head of c with the
+// keyboard, displaying its live position, until 'q' is pressed.
+func jog(ctx context.Context, c *snappy.Conn) error {
+	restore, err := rawTerminal()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	interval := c.PollInterval()
+	defer c.SetPollInterval(interval)
+	c.SetPollInterval(250 * time.Millisecond)
+	events := c.Subscribe(ctx)
+
+	keys := make(chan string)
+	go readKeys(ctx, os.Stdin, keys)
+	// Stop reading stdin before the terminal is restored, so that no
+	// later key press is lost.
+	defer func() {
+		cancel()
+		for range keys {
+		}
+	}()
+
+	// In raw mode, line endings need an explicit carriage return.
+	fmt.Print(strings.ReplaceAll(jogHelp, "\n", "\r\n"), "\r\n")
+	step, cross, note := jogSteps[1], false, ""
+	show := func() {
+		x, y, z, ox, oy, oz := c.CurrentLocation()
+		fmt.Printf("\r\x1b[Kat (%.2f,%.2f,%.2f) offset=(%.2f,%.2f,%.2f) step=%gmm %s", x, y, z, ox, oy, oz, step, note)
+	}
+	show()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-events:
+			if !ok {
+				return snappy.ErrNotConnected
+			}
+			show()
+			continue
+		case k, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			note = ""
+			var err error
+			switch k {
+			case "q", "\x03":
+				fmt.Print("\r\n")
+				return nil
+			case "1", "2", "3", "4":
+				step = jogSteps[k[0]-'1']
+			case keyLeft:
+				err = c.Step(ctx, -step, 0, 0)
+			case keyRight:
+				err = c.Step(ctx, step, 0, 0)
+			case keyUp:
+				err = c.Step(ctx, 0, step, 0)
+			case keyDown:
+				err = c.Step(ctx, 0, -step, 0)
+			case keyPageUp:
+				err = c.Step(ctx, 0, 0, step)
+			case keyPageDown:
+				err = c.Step(ctx, 0, 0, -step)
+			case "o":
+				if err = c.SetOrigin(ctx); err == nil {
+					err = c.StatusContext(ctx)
+				}
+				note = "origin set"
+			case "g":
+				if err = c.GoToOrigin(ctx); err == nil {
+					err = c.StatusContext(ctx)
+				}
+			case "c":
+				cross = !cross
+				err = c.LaserCrossHairs(ctx, cross)
+				note = fmt.Sprintf("cross-hairs on=%v", cross)
+			case "s":
+				var d []byte
+				if d, err = c.SnapJPEG(ctx, 0); err == nil {
+					err = os.WriteFile("photo.jpg", d, 0666)
+				}
+				note = "photo.jpg saved"
+			default:
+				note = fmt.Sprintf("unknown key %q", k)
+			}
+			if err != nil {
+				note = fmt.Sprint("error: ", err)
+			}
+			show()
+		}
+	}
+}
+
+func cmdJog(ctx context.Context, args []string) error {
+	f := newFlags("jog", "", "Interactively move the tool head with the keyboard, displaying its\nlocation, and then report where it was left.\n\n"+jogHelp)
+	f.parse(args, 0, 0)
+	c, _, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	if err := requireHomed(c); err != nil {
+		return err
+	}
+	if err := jog(ctx, c); err != nil {
+		return err
+	}
+	return f.reportStatus(ctx, c)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// idleTerminal reads its keys, and then times out like a raw
+// terminal with no key pressed.
+type idleTerminal struct {
+	io.Reader
+}
+
+func (r idleTerminal) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if err == io.EOF {
+		time.Sleep(time.Millisecond)
+	}
+	return n, err
+}
+
+func TestReadKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	keys := make(chan string)
+	go readKeys(ctx, idleTerminal{strings.NewReader("q\x1b[A\x1b[5~\x1b[Zg")}, keys)
+	var got []string
+	for len(got) < 4 {
+		got = append(got, <-keys)
+	}
+	if want := []string{"q", keyUp, keyPageUp, "g"}; !slices.Equal(got, want) {
+		t.Errorf("got keys %q want %q", got, want)
+	}
+	cancel()
+	select {
+	case _, ok := <-keys:
+		if ok {
+			t.Error("got a key after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Error("readKeys still reading after cancel")
+	}
+}
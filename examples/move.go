@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"zappem.net/pub/net/snappy"
+)
+
+func cmdHome(ctx context.Context, args []string) error {
+	f := newFlags("home", "", "Home the machine. This is required after power on, and resets the work origin.")
+	fan := f.Int("fan", -1, "then set the enclosure fan speed (percent)")
+	f.parse(args, 0, 0)
+	c, _, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	if err := c.Home(ctx); err != nil {
+		return fmt.Errorf("failed to home device: %v", err)
+	}
+	if c.EnclosureFanNotRunning() && *fan <= 0 {
+		return errors.New("homed, but should start enclosure fan, use --fan=100")
+	}
+	if *fan >= 0 {
+		log.Printf("setting enclosure --fan to %d%%", *fan)
+		if err := c.EncFanContext(ctx, *fan); err != nil {
+			return fmt.Errorf("unable to set enclosure fan to %d: %v", *fan, err)
+		}
+	}
+	return f.reportStatus(ctx, c)
+}
+
+func cmdMove(ctx context.Context, args []string) error {
+	f := newFlags("move", "", `Move the tool head in one of these ways:
+
+  --x, --y, --z     to these work coordinates, other axes unchanged
+  --dx, --dy, --dz  step this many mm from the current location
+  --origin          to the work origin
+  --park            to the position for changing the tool head
+
+Moves other than steps first raise the tool head to a safe height
+above the work piece, move across, and then lower it.`)
+	x := f.Float64("x", 0, "move to this x work coordinate")
+	y := f.Float64("y", 0, "move to this y work coordinate")
+	z := f.Float64("z", 0, "move to this z work coordinate")
+	dx := f.Float64("dx", 0, "step this many mm in the X direction")
+	dy := f.Float64("dy", 0, "step this many mm in the Y direction")
+	dz := f.Float64("dz", 0, "step this many mm in the Z direction")
+	origin := f.Bool("origin", false, "move to the work origin")
+	park := f.Bool("park", false, "move to the tool head changing position")
+	direct := f.Bool("direct", false, "move in a straight line, not at a safe height")
+	workHeight := f.Float64("work-height", 0, "height (mm) of the work piece above the work origin, for safe height moves, overriding the profile SafeZ")
+	feed := f.Float64("feed", 0, "feed rate (mm/minute), 0 for the defaults")
+	f.parse(args, 0, 0)
+	set := make(map[string]bool)
+	f.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	absolute := set["x"] || set["y"] || set["z"]
+	relative := set["dx"] || set["dy"] || set["dz"]
+	ways := 0
+	for _, way := range []bool{absolute, relative, *origin, *park} {
+		if way {
+			ways++
+		}
+	}
+	if ways != 1 {
+		return errors.New("specify one of --{x,y,z}, --{dx,dy,dz}, --origin or --park")
+	}
+
+	c, t, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	if err := requireHomed(c); err != nil {
+		return err
+	}
+	var opts []snappy.MoveOption
+	if *feed > 0 {
+		opts = append(opts, snappy.WithFeed(*feed))
+	}
+	if *direct {
+		opts = append(opts, snappy.WithDirectMove())
+	}
+	if set["work-height"] {
+		c.SetSafeZ(0)
+		c.SetWorkHeight(*workHeight)
+	}
+
+	cx, cy, cz, ox, oy, oz := c.CurrentLocation()
+	switch {
+	case relative:
+		if err := c.Step(ctx, *dx, *dy, *dz, opts...); err != nil {
+			return fmt.Errorf("step (%.2f,%.2f,%.2f) failed: %v", *dx, *dy, *dz, err)
+		}
+	case *origin:
+		if err := c.GoToOrigin(ctx, opts...); err != nil {
+			return fmt.Errorf("failed to go to origin: %v", err)
+		}
+	case *park:
+		// Machine coordinates are work coordinates less the offsets.
+		p := c.Model().Park
+		id, _, _ := c.ToolHead(1)
+		if tp := t.tool(id).Park; len(tp) == 3 {
+			p = [3]float64(tp)
+		}
+		px, py, pz := ox+p[0], oy+p[1], oz+p[2]
+		if pz < 0 {
+			return fmt.Errorf("use --dz instead, --park would set negative z=%.2f", pz)
+		}
+		log.Printf("parking at (%.2f,%.2f,%.2f)", px, py, pz)
+		if err := c.MoveTo(ctx, px, py, pz, opts...); err != nil {
+			return fmt.Errorf("park at (%.2f,%.2f,%.2f) failed: %v", px, py, pz, err)
+		}
+	default:
+		if set["x"] {
+			cx = *x
+		}
+		if set["y"] {
+			cy = *y
+		}
+		if set["z"] {
+			cz = *z
+		}
+		if err := c.MoveTo(ctx, cx, cy, cz, opts...); err != nil {
+			return fmt.Errorf("move to (%.2f,%.2f,%.2f) failed: %v", cx, cy, cz, err)
+		}
+	}
+	return f.reportStatus(ctx, c)
+}
+
+func cmdOrigin(ctx context.Context, args []string) error {
+	f := newFlags("origin", "", "Set the work origin to the current location of the tool head.")
+	focus := f.Bool("focus", false, "first raise the tool head, touching the work surface, to the focus height of its profile calibration")
+	f.parse(args, 0, 0)
+	c, t, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	if err := requireHomed(c); err != nil {
+		return err
+	}
+	x, y, z, ox, oy, oz := c.CurrentLocation()
+	log.Printf("was at (%.2f,%.2f,%.2f) offset=(%.2f,%.2f,%.2f)", x, y, z, ox, oy, oz)
+	if *focus {
+		id, _, _ := c.ToolHead(1)
+		h := t.tool(id).FocusHeight
+		if h == 0 {
+			return fmt.Errorf("machine %q has no focus height for toolID=%d(%q), use: snappy config tool --focus", t.name, id, snappy.ModuleNames[id])
+		}
+		log.Printf("raising to focus height %.2f mm", h)
+		if err := c.Step(ctx, 0, 0, h); err != nil {
+			return fmt.Errorf("raise to focus height failed: %v", err)
+		}
+	}
+	if err := c.SetOrigin(ctx); err != nil {
+		return fmt.Errorf("failed to set origin: %v", err)
+	}
+	if _, err := c.AwaitState(ctx, 0, snappy.StateIdle); err != nil {
+		return fmt.Errorf("waiting for idle failed: %v", err)
+	}
+	return f.reportStatus(ctx, c)
+}
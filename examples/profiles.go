@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"zappem.net/pub/net/snappy"
+	"zappem.net/pub/net/snappy/config"
+)
+
+// configCommands lists the subcommands of the config command.
+var configCommands = []command{
+	{"list", "list the machine profiles", cmdConfigList},
+	{"show", "display a machine profile", cmdConfigShow},
+	{"add", "add or update a machine profile", cmdConfigAdd},
+	{"remove", "remove a machine profile", cmdConfigRemove},
+	{"tool", "record the calibration of a tool head", cmdConfigTool},
+}
+
+func cmdConfig(ctx context.Context, args []string) error {
+	return runSubcommand(ctx, "config", configCommands, args)
+}
+
+// profileReport describes a machine profile, without its token.
+type profileReport struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+	Address string `json:"address"`
+	Model   string `json:"model,omitempty"`
+}
+
+func newProfileReport(conf *config.Config, name string) profileReport {
+	p := conf.Machines[name]
+	return profileReport{
+		Name:    name,
+		Default: name == conf.Default,
+		Address: p.Address,
+		Model:   p.Model,
+	}
+}
+
+func (r profileReport) String() string {
+	mark := " "
+	if r.Default {
+		mark = "*"
+	}
+	return fmt.Sprintf("%s %s\t%s\t%s", mark, r.Name, r.Address, r.Model)
+}
+
+// parseTriple parses a comma separated (x,y,z) triple.
+func parseTriple(s string) ([]float64, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("want x,y,z, not %q", s)
+	}
+	var v []float64
+	for _, field := range fields {
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad value in %q: %v", s, err)
+		}
+		v = append(v, f)
+	}
+	return v, nil
+}
+
+func cmdPair(ctx context.Context, args []string) error {
+	f := newFlags("pair", "<address>", `Request a token from the machine at the address, which must be
+accepted on its touchscreen, and record both in the --machine profile
+(by default "default") of --config.`)
+	addr := f.parse(args, 1, 1)[0]
+	conf, err := loadConfig(false)
+	if err != nil {
+		return err
+	}
+	name := *machine
+	if name == "" {
+		name = config.DefaultName
+	}
+	log.Printf("requesting token from %q: accept the connection on its touchscreen", addr)
+	token, err := snappy.RequestToken(ctx, addr)
+	if err != nil {
+		return fmt.Errorf("pairing with %q failed: %v", addr, err)
+	}
+	// Pairing again keeps the calibrations of the profile.
+	p := conf.Machines[name]
+	p.Address, p.Token = addr, token
+	if c, err := snappy.NewConn(ctx, addr, token); err != nil {
+		log.Printf("unable to connect to %q to record its model: %v", addr, err)
+	} else {
+		p.Model = c.Model().Name
+		c.CloseContext(ctx)
+	}
+	if err := conf.Set(name, p); err != nil {
+		return err
+	}
+	if err := saveConfig(conf); err != nil {
+		return err
+	}
+	return f.report(newProfileReport(conf, name), fmt.Sprintf("--config=%q machine %q updated for %q", *configFile, name, addr))
+}
+
+func cmdConfigList(ctx context.Context, args []string) error {
+	f := newFlags("config list", "", "List the machine profiles of --config, marking the default with *.")
+	f.parse(args, 0, 0)
+	conf, err := loadConfig(false)
+	if err != nil {
+		return err
+	}
+	rs := []profileReport{}
+	var lines []string
+	for _, name := range conf.Names() {
+		r := newProfileReport(conf, name)
+		rs = append(rs, r)
+		lines = append(lines, r.String())
+	}
+	return f.report(rs, strings.Join(lines, "\n"))
+}
+
+func cmdConfigShow(ctx context.Context, args []string) error {
+	f := newFlags("config show", "[name]", "Display a machine profile, by default the --machine profile, with\nits token redacted.")
+	if args := f.parse(args, 0, 1); len(args) == 1 {
+		*machine = args[0]
+	}
+	t, err := selectTarget()
+	if err != nil {
+		return err
+	}
+	p := t.profile
+	if p.Token != "" {
+		p.Token = "REDACTED"
+	}
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return f.report(struct {
+		Name    string         `json:"name"`
+		Profile config.Profile `json:"profile"`
+	}{t.name, p}, fmt.Sprintf("%s: %s", t.name, b))
+}
+
+func cmdConfigAdd(ctx context.Context, args []string) error {
+	f := newFlags("config add", "<name>", `Add the named machine profile to --config or, if it exists, update the
+values given by flags. Use "snappy pair" to obtain a token.`)
+	address := f.String("address", "", "IP address of the machine")
+	token := f.String("token", "", "token of the machine")
+	model := f.String("model", "", "model of the machine, for example A350")
+	safeZ := f.Float64("safe-z", 0, "work coordinate height (mm) of absolute moves, 0 for the tool head clearance")
+	minZ := f.Float64("min-z", 0, "lowest machine Z coordinate (mm) to move to")
+	def := f.Bool("default", false, "make this the default profile")
+	name := f.parse(args, 1, 1)[0]
+	set := make(map[string]bool)
+	f.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	conf, err := loadConfig(false)
+	if err != nil {
+		return err
+	}
+	p, exists := conf.Machines[name]
+	if set["address"] {
+		p.Address = *address
+	}
+	if set["token"] {
+		p.Token = *token
+	}
+	if set["model"] {
+		p.Model = *model
+	}
+	if set["safe-z"] {
+		p.SafeZ = *safeZ
+	}
+	if set["min-z"] {
+		p.MinZ = *minZ
+	}
+	if err := conf.Set(name, p); err != nil {
+		return err
+	}
+	if *def {
+		conf.Default = name
+	}
+	if err := saveConfig(conf); err != nil {
+		return err
+	}
+	verb := "added"
+	if exists {
+		verb = "updated"
+	}
+	r := newProfileReport(conf, name)
+	return f.report(r, fmt.Sprint(verb, ":\n", r))
+}
+
+func cmdConfigRemove(ctx context.Context, args []string) error {
+	f := newFlags("config remove", "<name>", "Remove the named machine profile from --config.")
+	name := f.parse(args, 1, 1)[0]
+	conf, err := loadConfig(true)
+	if err != nil {
+		return err
+	}
+	if err := conf.Remove(name); err != nil {
+		return err
+	}
+	if err := saveConfig(conf); err != nil {
+		return err
+	}
+	return f.report(struct {
+		Name string `json:"name"`
+	}{name}, fmt.Sprintf("removed %q", name))
+}
+
+func cmdConfigTool(ctx context.Context, args []string) error {
+	f := newFlags("config tool", "<toolID>", `Record the calibration of the tool head, with the ID of "snappy
+status", in the --machine profile. The camera offset can also be
+recorded with "snappy camera offset".`)
+	focus := f.Float64("focus", 0, "height (mm) above the work surface at which the laser is in focus")
+	park := f.String("park", "", "machine coordinates x,y,z at which to park the tool head")
+	camera := f.String("camera", "", "offset dx,dy,dz of the camera from the tool head")
+	arg := f.parse(args, 1, 1)[0]
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return fmt.Errorf("invalid toolID %q", arg)
+	}
+	set := make(map[string]bool)
+	f.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	t, err := selectTarget()
+	if err != nil {
+		return err
+	}
+	tool := t.tool(id)
+	if set["focus"] {
+		tool.FocusHeight = *focus
+	}
+	if set["park"] {
+		if tool.Park, err = parseTriple(*park); err != nil {
+			return fmt.Errorf("--park: %v", err)
+		}
+	}
+	if set["camera"] {
+		if tool.CameraOffset, err = parseTriple(*camera); err != nil {
+			return fmt.Errorf("--camera: %v", err)
+		}
+	}
+	t.setTool(id, tool)
+	if err := t.save(); err != nil {
+		return err
+	}
+	return f.report(tool, fmt.Sprintf("machine %q toolID=%d(%q) focus=%gmm park=%v camera=%v", t.name, id, snappy.ModuleNames[id], tool.FocusHeight, tool.Park, tool.CameraOffset))
+}
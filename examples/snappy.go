@@ -1,519 +1,378 @@
 // Program snappy is a demonstration command line utility to drive a
-// Snapmaker 2.0 A350.
+// Snapmaker 2.0 machine, such as the A350.
+//
+// Usage:
+//
+//	snappy [--config=FILE] [--machine=NAME] <command> [flags] [args]
+//
+// Each command accepts --json to write its result to stdout as JSON,
+// for driving the tool from scripts. Run "snappy help" for the list
+// of commands.
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"image"
-	"image/color"
-	"image/draw"
-	"image/jpeg"
 	"log"
-	"math"
 	"os"
-	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
-	"zappem.net/pub/graphics/raster"
 	"zappem.net/pub/net/snappy"
+	"zappem.net/pub/net/snappy/config"
 )
 
 var (
-	config     = flag.String("config", "snapmaker.config", "config file")
-	camOffset  = flag.Bool("set-camera-offset", false, "set camera offset for current tool to --x --y --z and exit")
-	home       = flag.Bool("home", false, "home device (required after power on)")
-	fan        = flag.Int("fan", -1, "enable the enclosure fan")
-	led        = flag.Int("led", -1, "enable the led lighting")
-	x          = flag.Float64("x", 192.5, "specify x value for location")
-	y          = flag.Float64("y", 170, "specify y value for location")
-	z          = flag.Float64("z", 113, "specify z value for location")
-	zd         = flag.Float64("zd", 1, "zoom dz delta from --{x,y,z} for --zoom pictures")
-	move       = flag.Bool("move", false, "move to the specified --x --y --z location")
-	spot       = flag.Bool("spot", false, "turn on the spot laser")
-	cross      = flag.Bool("cross", false, "turn on the laser cross")
-	nocross    = flag.Bool("nocross", false, "turn off the laser cross")
-	nospot     = flag.Bool("nospot", false, "turn off the spot laser")
-	locate     = flag.Bool("locate", false, "display the current coordinates")
-	photo      = flag.Bool("photo", false, "request a single photo at current location")
-	snapshot   = flag.Bool("snap", false, "request single photo at previously configured --set-camera-offset")
-	circle     = flag.Bool("circle", false, "request a series of photos taken in a circle around --{x,y,z}")
-	zoom       = flag.Bool("zoom", false, "request a series of zoomed (by --zd) photos starting at --{x,y,z}")
-	marks      = flag.Bool("marks", false, "mark all photos with targeting lines")
-	setOrigin  = flag.Bool("set-origin", false, "set the workspace origin to the current location")
-	gotoOrigin = flag.Bool("goto-origin", false, "move the tool head to the origin location")
-	nudgeX     = flag.Float64("nudge-x", 0.0, "step this many mm in the X direction")
-	nudgeY     = flag.Float64("nudge-y", 0.0, "step this many mm in the Y direction")
-	nudgeZ     = flag.Float64("nudge-z", 0.0, "step this many mm in the Z direction")
-	edit       = flag.String("edit", "", "comment out comma separated sets of --program lines, <n> or <n>-<m>")
-	program    = flag.String("program", "", "upload and execute a program")
-	park       = flag.Bool("park", false, "park the head for changing and exit")
-	pause      = flag.Bool("pause", false, "pause the executing program")
-	resume     = flag.Bool("resume", false, "resume the executing program")
-	stop       = flag.Bool("stop", false, "stop the executing program")
-	poll       = flag.Bool("poll", false, "poll running program until complete")
-	dump       = flag.Bool("dump", false, "dump the last cached a350 state and exit")
+	configFile = flag.String("config", "snapmaker.config", "config file of the machine profiles")
+	machine    = flag.String("machine", "", "machine profile to use, overriding $"+config.EnvMachine+" and the default profile")
 )
 
-type ToolConfig struct {
-	// CameraDeltaCoords, if set, holds the (dx,dy,dz) tool offset
-	// to take an in-focus centered photo relative to the initial
-	// tool head location. The --snap argument will nudge the head
-	// by this amount, take a photo, and then un-nudge the head by
-	// that same amount.
-	CameraDeltaCoords []float64 `json:"CameraCoordsDelta,omitempty"`
+// loadConfig reads the --config file. A missing file is only an
+// error if it must exist.
+func loadConfig(mustExist bool) (*config.Config, error) {
+	conf, err := config.Load(*configFile)
+	if err != nil {
+		if !mustExist && errors.Is(err, os.ErrNotExist) {
+			return &config.Config{}, nil
+		}
+		return nil, fmt.Errorf("failed to read --config=%q: %v", *configFile, err)
+	}
+	return conf, nil
 }
 
-// Config holds the static config for the target printer. See the
-// --config option for overriding its default location.
-type Config struct {
-	Token   string
-	Address string
-	Tools   map[int]ToolConfig
+// saveConfig writes conf to the --config file.
+func saveConfig(conf *config.Config) error {
+	if err := conf.Save(*configFile); err != nil {
+		return fmt.Errorf("failed to write config %q: %v", *configFile, err)
+	}
+	return nil
 }
 
-// grep performs a regexp match in an array of []byte lines.
-// Returns the number of the matching line (starts at 0), the
-// string content of that line or an error.
-func grep(lines [][]byte, val string) (int, string, error) {
-	re, err := regexp.Compile(val)
-	if err != nil {
-		return 0, "", err
-	}
-	for n, line := range lines {
-		if re.Match(line) {
-			return n, string(line), nil
-		}
-	}
-	return 0, "", fmt.Errorf("%q does not match", val)
+// target is the machine profile selected from the --config file.
+type target struct {
+	conf    *config.Config
+	name    string
+	profile config.Profile
 }
 
-// markUp overlays some targeting lines on an image.
-func markUp(jp []byte) (draw.Image, error) {
-	buf := bytes.NewBuffer(jp)
-	im, format, err := image.Decode(buf)
+// selectTarget selects the --machine profile from the --config file.
+func selectTarget() (target, error) {
+	conf, err := loadConfig(true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode %q image: %v", format, err)
-	}
-	bb := im.Bounds()
-	w := bb.Max.X - bb.Min.X
-	if h := bb.Max.Y - bb.Min.Y; h < w {
-		w = h
-	}
-	r := .1 * float64(w)
-	pen := raster.NewRasterizer()
-	const delta = 3.0
-	const wide = 2.0
-	const base = 4.0
-	const mag = 0.75
-	raster.LineTo(pen, false, base+0, base+0, base+r-delta, base+r-delta, wide)
-	raster.LineTo(pen, false, base+2*r, base+2*r, base+r+delta, base+r+delta, wide)
-	raster.LineTo(pen, false, base+2*r, base+0, base+r+delta, base+r-delta, wide)
-	raster.LineTo(pen, false, base+0, base+2*r, base+r-delta, base+r+delta, wide)
-	raster.LineTo(pen, false, base+r-r*mag, base+4*r/3, base+r-r*mag, base+2*r/3, wide/2)
-	raster.LineTo(pen, false, base+r+r*mag, base+4*r/3, base+r+r*mag, base+2*r/3, wide/2)
-	raster.LineTo(pen, false, base+4*r/3, base+r-r*mag, base+2*r/3, base+r-r*mag, wide/2)
-	raster.LineTo(pen, false, base+4*r/3, base+r+r*mag, base+2*r/3, base+r+r*mag, wide/2)
-	out := image.NewRGBA(bb)
-	draw.Draw(out, bb, im, image.ZP, draw.Src)
-	pen.Render(out, float64(bb.Min.X+bb.Max.X)/2-(r+base), float64(bb.Min.Y+bb.Max.Y)/2-(r+base), color.RGBA{255, 0, 255, 255})
-	return out, nil
+		return target{}, err
+	}
+	name, p, err := conf.Select(*machine)
+	if err != nil {
+		return target{}, fmt.Errorf("no --machine profile in --config=%q: %v", *configFile, err)
+	}
+	return target{conf: conf, name: name, profile: p}, nil
 }
 
-func processJPEG(d []byte) []byte {
-	if *marks {
-		im, err := markUp(d)
-		if err != nil {
-			log.Fatalf("failed to mark up the image: %v", err)
-		}
-		buf := &bytes.Buffer{}
-		if err := jpeg.Encode(buf, im, nil); err != nil {
-			log.Fatalf("failed to encode jpeg: %v", err)
-		}
-		d = buf.Bytes()
+// save records the profile of t in the --config file.
+func (t target) save() error {
+	if err := t.conf.Set(t.name, t.profile); err != nil {
+		return err
 	}
-	return d
+	return saveConfig(t.conf)
 }
 
-func main() {
-	flag.Parse()
-
-	ctx := context.Background()
+// tool returns the calibration of the tool head in the profile of t.
+func (t target) tool(id int) config.Tool {
+	return t.profile.Tools[id]
+}
 
-	data, err := os.ReadFile(*config)
-	if err != nil {
-		log.Fatalf("failed to read --config=%q: %v", *config, err)
-	}
-	var conf Config
-	if err := json.Unmarshal(data, &conf); err != nil {
-		log.Fatalf("failed to import %q: %v", *config, err)
+// setTool replaces the calibration of the tool head in the profile of
+// t.
+func (t *target) setTool(id int, tool config.Tool) {
+	if t.profile.Tools == nil {
+		t.profile.Tools = make(map[int]config.Tool)
 	}
+	t.profile.Tools[id] = tool
+}
 
-	c, err := snappy.NewConn(ctx, conf.Address, conf.Token)
+// connect connects to the machine of the selected profile, applying
+// its limits and safe height.
+func connect(ctx context.Context) (*snappy.Conn, target, error) {
+	t, err := selectTarget()
 	if err != nil {
-		log.Fatalf("unable to connect to %q: %v", conf.Address, err)
+		return nil, t, err
 	}
-	defer c.Close()
-
-	toolID, ok, err := c.ToolHead(1)
+	c, err := t.profile.Connect(ctx)
 	if err != nil {
-		log.Fatalf("failed to get key=1 detail: %v", err)
+		return nil, t, fmt.Errorf("unable to connect to %q (%s): %v", t.name, t.profile.Address, err)
 	}
-
-	if *dump {
-		ms := c.ModuleList()
-		log.Printf("connected modules: %#v", ms)
-		c.DumpState()
-		log.Printf("toolID=%d(%q) ok=%v", toolID, snappy.ModuleNames[toolID], ok)
-		log.Printf("tool config: %#v", conf.Tools[toolID])
-		return
+	if err := c.StatusContext(ctx); err != nil {
+		c.CloseContext(ctx)
+		return nil, t, fmt.Errorf("failed to read status: %v", err)
 	}
+	return c, t, nil
+}
 
-	if err := c.Status(); err != nil {
-		log.Fatalf("failed to read status: %v", err)
+// requireHomed confirms the machine has been homed, which is needed
+// before it can be moved.
+func requireHomed(c *snappy.Conn) error {
+	if !c.Homed() {
+		return errors.New("device is not homed yet, use: snappy home")
 	}
+	return nil
+}
 
-	if !c.Homed() {
-		if !*home {
-			log.Fatal("device is not homed yet, use --home")
+// flags holds the flags of a command.
+type flags struct {
+	*flag.FlagSet
+	json bool
+}
+
+// newFlags returns the flags of the named command, including --json.
+// The args and help strings describe the command for its usage.
+func newFlags(name, args, help string) *flags {
+	f := &flags{FlagSet: flag.NewFlagSet(name, flag.ExitOnError)}
+	f.BoolVar(&f.json, "json", false, "write the result to stdout as JSON")
+	f.Usage = func() {
+		line := strings.TrimSpace(fmt.Sprint("snappy [--config=FILE] [--machine=NAME] ", name, " [flags] ", args))
+		fmt.Fprintf(f.Output(), "usage: %s\n\n%s\n\nflags:\n", line, help)
+		f.PrintDefaults()
+	}
+	return f
+}
+
+// parse parses the flags of a command from args, where they may
+// also follow its arguments, returning the arguments. The usage is
+// displayed, and the program exits, unless there are between min and
+// max arguments.
+func (f *flags) parse(args []string, min, max int) []string {
+	var rest []string
+	for {
+		f.Parse(args)
+		if args = f.Args(); len(args) == 0 {
+			break
 		}
+		rest, args = append(rest, args[0]), args[1:]
 	}
-	if *home {
-		if err := c.Home(ctx); err != nil {
-			log.Fatalf("failed to home device: %v", err)
-		}
-		if c.EnclosureFanNotRunning() && *fan <= 0 {
-			log.Fatal("homed, but should start enclosure fan!")
-		}
+	if len(rest) < min || len(rest) > max {
+		f.Usage()
+		os.Exit(2)
 	}
+	return rest
+}
 
-	if *fan >= 0 && *fan <= 100 {
-		log.Printf("setting enclosure --fan to %d%%", *fan)
-		if err := c.EncFan(*fan); err != nil {
-			log.Fatalf("unable to set enclosure fan to %d: %v", *fan, err)
-		}
+// report writes the result of a command to stdout. The result is v
+// encoded as JSON with --json, and otherwise the text.
+func (f *flags) report(v any, text string) error {
+	if f.json {
+		return json.NewEncoder(os.Stdout).Encode(v)
 	}
+	fmt.Println(text)
+	return nil
+}
 
-	if *led >= 0 && *led <= 100 {
-		log.Printf("setting enclosure --led to %d%%", *led)
-		if err := c.EncLED(*led); err != nil {
-			log.Fatalf("unable to set enclosure LED to %d: %v", *led, err)
-		}
-	}
+// statusReport is the result of commands that report the state of
+// the machine.
+type statusReport struct {
+	Model  string              `json:"model"`
+	ToolID int                 `json:"toolId"`
+	Tool   string              `json:"tool"`
+	Homed  bool                `json:"homed"`
+	Status snappy.StatusResult `json:"status"`
+}
 
-	if *locate || *park {
-		c.Status()
-		x, y, z, ox, oy, oz := c.CurrentLocation()
-		if *park {
-			const tx = -179
-			const ty = -327
-			const tz = -156.5
-			x, y, z = ox-tx, oy-ty, oz-tz
-			if z < 0 {
-				log.Fatalf("use --nudge-{x,y,z} instead --park would set negative z=%.2f", z)
-			}
-			log.Printf("parking at (%.2f,%.2f,%.2f)", x, y, z)
-			if err := c.MoveTo(ctx, x, y, z); err != nil {
-				log.Fatalf("park at (%.2f,%.2f,%.2f) failed: %v", x, y, z, err)
-			}
-			return
-		}
-		log.Printf("at (%.2f,%.2f,%.2f) offset=(%.2f,%.2f,%.2f)", x, y, z, ox, oy, oz)
-	}
+// newStatusReport refreshes the status of c and summarizes it.
+func newStatusReport(ctx context.Context, c *snappy.Conn) (statusReport, error) {
+	if err := c.StatusContext(ctx); err != nil {
+		return statusReport{}, fmt.Errorf("failed to read status: %v", err)
+	}
+	id, _, _ := c.ToolHead(1)
+	return statusReport{
+		Model:  c.Model().Name,
+		ToolID: id,
+		Tool:   snappy.ModuleNames[id],
+		Homed:  c.Homed(),
+		Status: c.LastStatus(),
+	}, nil
+}
 
-	if *camOffset {
-		switch toolID {
-		case 2: // TODO support 10W Laser too
-		default:
-			log.Fatalf("toolID=%d(%q) has no supported camera", toolID, snappy.ModuleNames[toolID])
-		}
-		if conf.Tools == nil {
-			conf.Tools = make(map[int]ToolConfig)
-		}
-		tool := conf.Tools[toolID]
-		tool.CameraDeltaCoords = []float64{*x, *y, *z}
-		conf.Tools[toolID] = tool
-		b, err := json.Marshal(conf)
-		if err != nil {
-			log.Fatalf("failed to marshal config: %v", err)
-		}
-		if err := os.WriteFile(*config, b, 0600); err != nil {
-			log.Fatalf("failed to write config %q: %v", *config, err)
-		}
-		log.Printf("--config=%q updated with camera offset for toolID=%d(%q)", *config, toolID, snappy.ModuleNames[toolID])
-		return
-	}
+// location describes the tool head location of r.
+func (r statusReport) location() string {
+	st := r.Status
+	return fmt.Sprintf("at (%.2f,%.2f,%.2f) offset=(%.2f,%.2f,%.2f)", st.X, st.Y, st.Z, st.OffsetX, st.OffsetY, st.OffsetZ)
+}
 
-	if *gotoOrigin {
-		if err := c.GoToOrigin(ctx); err != nil {
-			log.Fatalf("failed to go to origin: %v", err)
-		}
-	}
+func (r statusReport) String() string {
+	return fmt.Sprintf("%s %s tool=%d(%q) homed=%v %s", r.Model, r.Status.Status, r.ToolID, r.Tool, r.Homed, r.location())
+}
 
-	if *pause {
-		if err := c.PauseProgram(); err != nil {
-			log.Fatalf("failed to pause: %v", err)
-		}
-		return
+// reportStatus reports the refreshed status of c as the result of a
+// command, with the text of the tool head location.
+func (f *flags) reportStatus(ctx context.Context, c *snappy.Conn) error {
+	r, err := newStatusReport(ctx, c)
+	if err != nil {
+		return err
 	}
+	return f.report(r, r.location())
+}
 
-	if *resume {
-		if err := c.ResumeProgram(); err != nil {
-			log.Fatalf("failed to resume: %v", err)
-		}
-		return
+// command is a subcommand of the tool.
+type command struct {
+	name, help string
+	run        func(ctx context.Context, args []string) error
+}
+
+// commands lists the subcommands of the tool.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"discover", "list the Snapmaker machines found on the LAN", cmdDiscover},
+		{"pair", "obtain a token from a machine and write it to --config", cmdPair},
+		{"config", "list, show, add or remove machine profiles", cmdConfig},
+		{"status", "display the state of the machine", cmdStatus},
+		{"home", "home the machine (required after power on)", cmdHome},
+		{"move", "move the tool head", cmdMove},
+		{"origin", "set the work origin to the current location", cmdOrigin},
+		{"jog", "interactively move the tool head with the keyboard", cmdJog},
+		{"job", "run, pause, resume, stop, watch or edit programs", cmdJob},
+		{"camera", "take photos with the tool head camera", cmdCamera},
+		{"enclosure", "display or set the enclosure fan and LED", cmdEnclosure},
+		{"laser", "control the laser spot, cross-hairs and air assist", cmdLaser},
+		{"spindle", "start or stop the CNC spindle", cmdSpindle},
+		{"print", "control the 3D printing nozzle, bed and filament", cmdPrint},
+		{"help", "describe the commands", cmdHelp},
 	}
+}
 
-	if *stop {
-		if err := c.StopProgram(); err != nil {
-			log.Fatalf("failed to stop: %v", err)
-		}
-		return
+// usage describes the commands of the tool.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: snappy [--config=FILE] [--machine=NAME] <command> [flags] [args]\n\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", cmd.name, cmd.help)
 	}
+	fmt.Fprintln(out, "\nUse \"snappy <command> --help\" for the flags of a command.\n\nflags:")
+	flag.PrintDefaults()
+}
 
-	if *edit != "" {
-		if *program == "" {
-			log.Fatal("--edit requires --program to be defined")
-		}
-		data, err := os.ReadFile(*program)
-		if err != nil {
-			log.Fatalf("unable to read %q: %v", *program, err)
-		}
-		lines := bytes.Split(data, []byte("\n"))
-		for _, sec := range strings.Split(*edit, ",") {
-			nums := strings.Split(sec, "-")
-			if len(nums) > 2 {
-				log.Fatalf("--edit requires <n> or <n>-<m> fields; invalid: %q", sec)
-			}
-			from, err := strconv.Atoi(nums[0])
-			if err != nil {
-				log.Fatalf("failed to parse --edit=..%q..: %v", nums[0])
-			}
-			if from > len(lines) {
-				log.Fatalf("%q is out of bounds for %q (length=%d)", sec, *program, len(lines))
+// runSubcommand runs the command of cmds named by the first of
+// args, the remaining args being its arguments. Without a known
+// command, the cmds of the parent command are described.
+func runSubcommand(ctx context.Context, parent string, cmds []command, args []string) error {
+	if len(args) != 0 {
+		for _, cmd := range cmds {
+			if cmd.name == args[0] {
+				return cmd.run(ctx, args[1:])
 			}
-			to := from
-			if len(nums) == 2 {
-				to, err = strconv.Atoi(nums[1])
-				if err != nil {
-					if nums[1] != "" {
-						log.Fatalf("failed to parse 2nd number from --edit=..%q..: %v", sec, err)
-					}
-					to = len(lines)
-				}
-				if to < from {
-					log.Fatalf("--edit range is b>=a, not %d", sec)
-				}
-				if to > len(lines) {
-					log.Fatalf("--edit range beyond length of --program %q vs %d", sec, len(lines))
-				}
-			}
-			for i := from - 1; i < to; i++ {
-				line := lines[i]
-				if len(line) == 0 {
-					continue
-				}
-				if bytes.HasPrefix(line, []byte(";")) {
-					continue
-				}
-				lines[i] = append([]byte(";"), lines[i]...)
-			}
-		}
-		replacement := bytes.Join(lines, []byte("\n"))
-		output := fmt.Sprint("edited-", filepath.Base(*program))
-		if err := os.WriteFile(output, replacement, 0666); err != nil {
-			log.Fatalf("failed to write edited program %q: %v", output, err)
-		}
-		return
-	}
-	if *program != "" {
-		data, err := os.ReadFile(*program)
-		if err != nil {
-			log.Fatalf("unable to read %q: %v", *program, err)
-		}
-		lines := bytes.Split(data, []byte("\n"))
-		n, line, err := grep(lines, "^;estimated_time")
-		if err != nil {
-			log.Printf("no estimated time for completion [%d]: %v", n, err)
-		} else if val, err := strconv.ParseFloat(line[20:], 64); err != nil {
-			log.Printf("failed to parse line=%d %q: %v", n, line, err)
-		} else {
-			when := time.Now().Add(time.Microsecond * time.Duration(1e6*val))
-			log.Printf("ETA for completion from file: %s", when.Format(time.DateTime))
-		}
-		if err := c.RunProgram(*program, data); err != nil {
-			log.Fatalf("failed to upload and run %q: %v", *program, err)
-		}
-		if !*poll {
-			return
-		}
-		log.Println("[waiting to start]")
-		if err := c.Await(ctx, "RUNNING"); err != nil {
-			log.Fatalf("waiting to start running failed: %v", err)
 		}
 	}
-	if *poll {
-		log.Println("[waiting for idle]")
-		done := make(chan struct{})
-		ready := make(chan struct{})
-		go func() {
-			defer close(ready)
-			polled := false
-			for {
-				select {
-				case <-time.After(3 * time.Second):
-					ok, status := c.Running()
-					fmt.Printf("\r%s\033[0K", status)
-					polled = true
-					if !ok {
-						fmt.Println()
-						return
-					}
-				case <-done:
-					if polled {
-						fmt.Println()
-					}
-					return
-				}
-			}
-		}()
-		if err := c.Await(ctx, "IDLE"); err != nil {
-			log.Fatalf("waiting for idle failed: %v", err)
-		}
-		close(done)
-		<-ready
-		log.Println("[system is idle]")
-		return
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: snappy [--config=FILE] [--machine=NAME] %s <command> [flags] [args]\n\ncommands:\n", parent)
+	for _, cmd := range cmds {
+		fmt.Fprintf(out, "  %-10s %s\n", cmd.name, cmd.help)
 	}
+	os.Exit(2)
+	return nil
+}
+
+func cmdHelp(ctx context.Context, args []string) error {
+	usage()
+	return nil
+}
 
-	var dx, dy, dz float64
-	var nudged bool
-	if *nudgeX != 0 {
-		dx = *nudgeX
-		nudged = true
+func cmdDiscover(ctx context.Context, args []string) error {
+	f := newFlags("discover", "", "List the Snapmaker machines that reply on the LAN.")
+	timeout := f.Duration("timeout", 3*time.Second, "how long to wait for replies")
+	f.parse(args, 0, 0)
+	ms, err := snappy.Discover(ctx, *timeout)
+	if err != nil {
+		return fmt.Errorf("discovery failed: %v", err)
 	}
-	if *nudgeY != 0 {
-		dy = *nudgeY
-		nudged = true
+	if len(ms) == 0 {
+		if !f.json {
+			return errors.New("no machines found")
+		}
+		ms = []snappy.Machine{}
 	}
-	if *nudgeZ != 0 {
-		dz = *nudgeZ
-		nudged = true
+	lines := []string{}
+	for _, m := range ms {
+		lines = append(lines, fmt.Sprintf("%s\t%s\t%q\t%s", m.Address, m.Name, m.Model, m.Status))
 	}
+	return f.report(ms, strings.Join(lines, "\n"))
+}
 
-	if nudged {
-		if err := c.Step(ctx, dx, dy, dz); err != nil {
-			log.Fatalf("nudge (%.2f,%.2f,%.2f) failed: %v", dx, dy, dz, err)
-		}
-	} else if *move {
-		if err := c.MoveTo(ctx, *x, *y, *z); err != nil {
-			log.Fatalf("move to (%.2f,%.2f,%.2f) failed: %v", *x, *y, *z, err)
-		}
+func cmdStatus(ctx context.Context, args []string) error {
+	f := newFlags("status", "", "Display the state of the machine and the location of its tool head.")
+	dump := f.Bool("dump", false, "also log all of the cached machine state")
+	f.parse(args, 0, 0)
+	c, t, err := connect(ctx)
+	if err != nil {
+		return err
 	}
-
-	if *setOrigin {
-		c.Status()
-		x, y, z, ox, oy, oz := c.CurrentLocation()
-		log.Printf("was at (%.2f,%.2f,%.2f) offset=(%.2f,%.2f,%.2f)", x, y, z, ox, oy, oz)
-		if err := c.SetOrigin(ctx); err != nil {
-			log.Fatalf("failed to set origin: %v", err)
-		}
-		if err := c.Await(ctx, "IDLE"); err != nil {
-			log.Fatalf("waiting for idle failed: %v", err)
-		}
-		c.Status()
-		x, y, z, ox, oy, oz = c.CurrentLocation()
-		log.Printf("now at (%.2f,%.2f,%.2f) offset=(%.2f,%.2f,%.2f)", x, y, z, ox, oy, oz)
+	defer c.CloseContext(ctx)
+	r, err := newStatusReport(ctx, c)
+	if err != nil {
+		return err
 	}
-
-	if *nospot {
-		if err := c.LaserSpot(ctx, 0); err != nil {
-			log.Fatalf("failed to disable laser spot: %v", err)
-		}
-	} else if *spot {
-		if err := c.LaserSpot(ctx, 1.0); err != nil {
-			log.Fatalf("failed to enable laser spot: %v", err)
-		}
+	if *dump {
+		log.Printf("connected modules: %#v", c.ModuleList())
+		c.DumpState()
+		log.Printf("machine %q tool config: %#v", t.name, t.tool(r.ToolID))
 	}
-
-	if *nocross {
-		if err := c.LaserCrossHairs(ctx, false); err != nil {
-			log.Fatalf("laser cross failed to disable: %v", err)
-		}
-	} else if *cross {
-		if err := c.LaserCrossHairs(ctx, true); err != nil {
-			log.Fatalf("laser cross failed to enable: %v", err)
-		}
+	text := r.String()
+	if r.Status.TotalLines != 0 {
+		_, status := c.Running()
+		text += "\n" + status
 	}
+	return f.report(r, text)
+}
 
-	if *snapshot {
-		tool := conf.Tools[toolID]
-		dXYZ := tool.CameraDeltaCoords
-		if len(tool.CameraDeltaCoords) != 3 {
-			log.Fatalf("tool=%d(%q) camera offset unknown", toolID, snappy.ModuleNames[toolID])
-		}
-		cx, cy, cz, _, _, _ := c.CurrentLocation()
-		d, err := c.SnapAtJPEG(ctx, 0, cx+dXYZ[0], cy+dXYZ[1], cz+dXYZ[2])
-		if err != nil {
-			log.Fatalf("--snap failed at (%g,%g,%g): %v", cx+dXYZ[0], cy+dXYZ[1], cz+dXYZ[2], err)
-		}
-		d = processJPEG(d)
-		if err := os.WriteFile("photo.jpg", d, 0777); err != nil {
-			log.Fatalf("no --snap photo: %v", err)
-		}
-		if err := c.MoveTo(ctx, cx, cy, cz); err != nil {
-			log.Fatalf("return to (%.2f,%.2f,%.2f) failed: %v", cx, cy, cz, err)
-		}
-		return
+func cmdEnclosure(ctx context.Context, args []string) error {
+	f := newFlags("enclosure", "", "Display the enclosure state, after setting its fan or LED.")
+	fan := f.Int("fan", -1, "set the enclosure fan speed (percent)")
+	led := f.Int("led", -1, "set the enclosure LED brightness (percent)")
+	f.parse(args, 0, 0)
+	c, _, err := connect(ctx)
+	if err != nil {
+		return err
 	}
-
-	if *photo {
-		d, err := c.SnapJPEG(ctx, 0)
-		if err != nil {
-			log.Fatalf("photo grab failed: %v", err)
-		}
-		d = processJPEG(d)
-		if err := os.WriteFile("photo.jpg", d, 0777); err != nil {
-			log.Fatalf("no photo: %v", err)
+	defer c.CloseContext(ctx)
+	if _, ok := c.Enclosure(); !ok {
+		return errors.New("machine has no enclosure")
+	}
+	if *fan >= 0 {
+		if err := c.EncFanContext(ctx, *fan); err != nil {
+			return fmt.Errorf("unable to set enclosure fan to %d: %v", *fan, err)
 		}
-		return
 	}
-
-	if *circle {
-		for i := 0; i < 9; i++ {
-			theta := float64(i) / 9.0 * 2.0 * math.Pi
-			r := 15.0
-			log.Printf("taking photo %d (at %.2f deg)", i, theta/math.Pi*180)
-			d, err := c.SnapAtJPEG(ctx, i, *x+r*math.Cos(theta), *y+r*math.Sin(theta), *z)
-			if err != nil {
-				log.Fatalf("photo grab failed: %v", err)
-			}
-			if err := os.WriteFile(fmt.Sprintf("photo%d.jpg", i), d, 0777); err != nil {
-				log.Fatalf("no photo: %v", err)
-			}
+	if *led >= 0 {
+		if err := c.EncLEDContext(ctx, *led); err != nil {
+			return fmt.Errorf("unable to set enclosure LED to %d: %v", *led, err)
 		}
-		return
 	}
+	if err := c.StatusContext(ctx); err != nil {
+		return fmt.Errorf("failed to read status: %v", err)
+	}
+	enc, _ := c.Enclosure()
+	return f.report(enc, fmt.Sprintf("ready=%v door-enabled=%v fan=%d%% led=%d%%", enc.IsReady, enc.IsDoorEnabled, enc.Fan, enc.LED))
+}
 
-	if *zoom {
-		for i := 0; i < 9; i++ {
-			delta := float64(i) * *zd
-			height := *z - delta
-			log.Printf("taking photo %d (at %.2f mm)", i, height)
-			d, err := c.SnapAtJPEG(ctx, i, *x, *y, height)
-			if err != nil {
-				log.Fatalf("photo grab failed: %v", err)
-			}
-			if err := os.WriteFile(fmt.Sprintf("photo%d.jpg", i), d, 0777); err != nil {
-				log.Fatalf("no photo: %v", err)
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	name, args := flag.Arg(0), flag.Args()[1:]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(context.Background(), args); err != nil {
+				log.Fatalf("%s: %v", name, err)
 			}
+			return
 		}
-		return
 	}
+	log.Printf("unknown command %q", name)
+	usage()
+	os.Exit(2)
 }
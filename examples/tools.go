@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// onOff parses an "on" or "off" argument.
+func onOff(arg string) (bool, error) {
+	switch arg {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("want on or off, not %q", arg)
+}
+
+func cmdLaser(ctx context.Context, args []string) error {
+	f := newFlags("laser", "spot|cross|air on|off", `Control the laser tool head:
+
+  spot on|off   the low power laser spot, for alignment
+  cross on|off  the laser cross-hairs
+  air on|off    the air assist pump
+
+The safety lock of the 10W laser must be cleared on the touchscreen.`)
+	args = f.parse(args, 2, 2)
+	what := args[0]
+	if what != "spot" && what != "cross" && what != "air" {
+		f.Usage()
+		os.Exit(2)
+	}
+	on, err := onOff(args[1])
+	if err != nil {
+		return err
+	}
+	c, _, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	switch what {
+	case "spot":
+		power := 0.0
+		if on {
+			power = min(1, c.MaxSpotPower())
+		}
+		err = c.LaserSpot(ctx, power)
+	case "cross":
+		err = c.LaserCrossHairs(ctx, on)
+	case "air":
+		err = c.AirAssist(ctx, on)
+	}
+	if err != nil {
+		return fmt.Errorf("laser %s failed: %v", what, err)
+	}
+	return f.reportStatus(ctx, c)
+}
+
+func cmdSpindle(ctx context.Context, args []string) error {
+	f := newFlags("spindle", "<rpm>", "Start the CNC spindle at rpm, or with an rpm of 0 stop it.")
+	ccw := f.Bool("ccw", false, "turn the spindle counter-clockwise (200W CNC only)")
+	arg := f.parse(args, 1, 1)[0]
+	rpm, err := strconv.Atoi(arg)
+	if err != nil || rpm < 0 {
+		return fmt.Errorf("invalid rpm %q", arg)
+	}
+	c, _, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	if rpm == 0 {
+		if err := c.SpindleOff(ctx); err != nil {
+			return fmt.Errorf("failed to stop spindle: %v", err)
+		}
+	} else if err := c.SpindleOn(ctx, rpm, !*ccw); err != nil {
+		return fmt.Errorf("failed to start spindle at %d rpm: %v", rpm, err)
+	}
+	return f.reportStatus(ctx, c)
+}
+
+func cmdPrint(ctx context.Context, args []string) error {
+	f := newFlags("print", "", `Control the 3D printing tool head. Temperatures are set first, waiting
+until they are reached, and then filament is extruded or changed.`)
+	extruder := f.Int("extruder", 0, "extruder (0 or 1) of the 3D printing head to operate")
+	nozzle := f.Float64("nozzle", -1, "set the --extruder nozzle target temperature (C), 0 to turn off")
+	bed := f.Float64("bed", -1, "set the heated bed target temperature (C), 0 to turn off")
+	extrude := f.Float64("extrude", 0, "extrude (or with a negative value, retract) this many mm of filament")
+	filament := f.Float64("filament", 0, "change the --extruder filament, heating the nozzle to this temperature (C)")
+	f.parse(args, 0, 0)
+	c, _, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.CloseContext(ctx)
+	if *nozzle >= 0 {
+		if err := c.SetNozzleTemperature(ctx, *extruder, *nozzle); err != nil {
+			return fmt.Errorf("failed to set nozzle temperature: %v", err)
+		}
+	}
+	if *bed >= 0 {
+		if err := c.SetBedTemperature(ctx, *bed); err != nil {
+			return fmt.Errorf("failed to set bed temperature: %v", err)
+		}
+	}
+	if *nozzle > 0 || *bed > 0 {
+		log.Print("waiting for temperatures")
+		if _, err := c.AwaitTemperature(ctx, 2, 10*time.Minute); err != nil {
+			return fmt.Errorf("failed to reach temperature: %v", err)
+		}
+	}
+	if *extrude != 0 {
+		if err := c.Extrude(ctx, *extruder, *extrude); err != nil {
+			return fmt.Errorf("failed to extrude %g mm: %v", *extrude, err)
+		}
+	}
+	if *filament > 0 {
+		err := c.ChangeFilament(ctx, *extruder, *filament, func(msg string) error {
+			log.Printf("%s: press enter", msg)
+			_, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("filament change failed: %v", err)
+		}
+	}
+	r, err := newStatusReport(ctx, c)
+	if err != nil {
+		return err
+	}
+	st := r.Status
+	return f.report(r, fmt.Sprintf("nozzle=%g/%gC bed=%g/%gC", st.NozzleTemperature, st.NozzleTargetTemperature, st.HeatedBedTemperature, st.HeatedBedTargetTemperature))
+}
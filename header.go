@@ -0,0 +1,145 @@
+package snappy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// headerStart and headerEnd delimit the header Luban writes at the
+// start of the programs it generates.
+const (
+	headerStart = ";Header Start"
+	headerEnd   = ";Header End"
+)
+
+// Header holds the values of a program header. Only a subset of
+// the values Luban generates are included.
+type Header struct {
+	// Type is the kind of job, "laser", "cnc" or "3dp".
+	Type string
+
+	// ToolHead is the ToolHead.Name of the tool head the program
+	// was written for.
+	ToolHead string
+
+	// Machine is the MachineModel.Name of the target machine.
+	Machine string
+
+	// TotalLines is the number of lines in the program, not
+	// counting the header.
+	TotalLines int
+
+	// Min and Max bound the (x,y,z) coordinates the program moves
+	// to.
+	Min, Max [3]float64
+
+	// WorkSpeed and JogSpeed are the fastest G1 and G0 feed rates
+	// of the program in mm/minute.
+	WorkSpeed, JogSpeed float64
+
+	// Power is the highest M3 power percent of the program.
+	Power float64
+}
+
+// HasHeader indicates the program starts with a header.
+func HasHeader(program []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(program, " \t\r\n"), []byte(headerStart))
+}
+
+// NewHeader generates a header for program to run with the tool
+// head on the model of machine. Absolute (G90) and relative (G91)
+// moves are tracked to compute the bounds of the program.
+func NewHeader(th ToolHead, model MachineModel, program []byte) Header {
+	h := Header{
+		Type:     strings.ToLower(th.Kind.String()),
+		ToolHead: th.Name,
+		Machine:  model.Name,
+	}
+	var pos [3]float64
+	relative, moved := false, false
+	sc := bufio.NewScanner(bytes.NewReader(program))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		h.TotalLines++
+		line, _, _ := strings.Cut(sc.Text(), ";")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		words := make(map[byte]float64)
+		for _, f := range fields[1:] {
+			if len(f) < 2 {
+				continue
+			}
+			if v, err := strconv.ParseFloat(f[1:], 64); err == nil {
+				words[f[0]] = v
+			}
+		}
+		code := strings.ToUpper(fields[0])
+		switch code {
+		case "G90":
+			relative = false
+		case "G91":
+			relative = true
+		case "M3", "M4":
+			h.Power = math.Max(h.Power, words['P'])
+		case "G0", "G1":
+			if f, ok := words['F']; ok {
+				if code == "G0" {
+					h.JogSpeed = math.Max(h.JogSpeed, f)
+				} else {
+					h.WorkSpeed = math.Max(h.WorkSpeed, f)
+				}
+			}
+			for i, a := range []byte("XYZ") {
+				v, ok := words[a]
+				if !ok {
+					continue
+				}
+				if relative {
+					pos[i] += v
+				} else {
+					pos[i] = v
+				}
+			}
+			for i, v := range pos {
+				if !moved || v < h.Min[i] {
+					h.Min[i] = v
+				}
+				if !moved || v > h.Max[i] {
+					h.Max[i] = v
+				}
+			}
+			moved = true
+		}
+	}
+	return h
+}
+
+// WriteTo writes the header in the form Luban generates.
+func (h Header) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	fmt.Fprintln(&b, headerStart)
+	fmt.Fprintf(&b, ";header_type: %s\n", h.Type)
+	fmt.Fprintf(&b, ";tool_head: %s\n", h.ToolHead)
+	fmt.Fprintf(&b, ";machine: %s\n", h.Machine)
+	fmt.Fprintf(&b, ";file_total_lines: %d\n", h.TotalLines)
+	for i, a := range []string{"x", "y", "z"} {
+		fmt.Fprintf(&b, ";max_%s(mm): %g\n", a, h.Max[i])
+	}
+	for i, a := range []string{"x", "y", "z"} {
+		fmt.Fprintf(&b, ";min_%s(mm): %g\n", a, h.Min[i])
+	}
+	fmt.Fprintf(&b, ";work_speed(mm/minute): %g\n", h.WorkSpeed)
+	fmt.Fprintf(&b, ";jog_speed(mm/minute): %g\n", h.JogSpeed)
+	if h.Type == "laser" {
+		fmt.Fprintf(&b, ";power(%%): %g\n", h.Power)
+	}
+	fmt.Fprintln(&b, headerEnd)
+	return b.WriteTo(w)
+}
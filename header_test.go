@@ -0,0 +1,79 @@
+package snappy_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"zappem.net/pub/net/snappy"
+)
+
+func TestNewHeader(t *testing.T) {
+	th, err := snappy.ToolHeadByID(23)
+	if err != nil {
+		t.Fatalf("no IR laser: %v", err)
+	}
+	model, err := snappy.ModelByName("A350")
+	if err != nil {
+		t.Fatalf("no A350: %v", err)
+	}
+	prog := []byte("G90\nG0 X10 Y5 F3000 ; to start\nM3 P40\nG1 X20 F600\nG91\nG1 Y-10 Z1\nM5\n")
+	if snappy.HasHeader(prog) {
+		t.Fatal("program without a header has one")
+	}
+	h := snappy.NewHeader(th, model, prog)
+	want := snappy.Header{
+		Type:       "laser",
+		ToolHead:   "2W Laser Module",
+		Machine:    "A350",
+		TotalLines: 7,
+		Min:        [3]float64{10, -5, 0},
+		Max:        [3]float64{20, 5, 1},
+		WorkSpeed:  600,
+		JogSpeed:   3000,
+		Power:      40,
+	}
+	if h != want {
+		t.Errorf("got %+v want %+v", h, want)
+	}
+	var b bytes.Buffer
+	if _, err := h.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if !snappy.HasHeader(b.Bytes()) || !bytes.HasSuffix(b.Bytes(), []byte(";Header End\n")) {
+		t.Errorf("bad header:\n%s", b.String())
+	}
+	if !strings.Contains(b.String(), ";tool_head: 2W Laser Module\n") {
+		t.Errorf("header missing tool head:\n%s", b.String())
+	}
+}
+
+func TestLaserIR(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+	s.SetToolHead(23)
+	// Even if the status claims a camera, the IR head has none.
+	s.Update(func(st *snappy.StatusResult) { st.LaserCamera = true })
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if th, err := c.Tool(); err != nil || th.ID != 23 || th.Kind != snappy.ToolLaser || !th.NeedsHeader {
+		t.Fatalf("Tool got %+v, %v", th, err)
+	}
+	if _, err := c.SnapJPEG(ctx, 0); !errors.Is(err, snappy.ErrNoCamera) {
+		t.Errorf("SnapJPEG got %v want %v", err, snappy.ErrNoCamera)
+	}
+
+	data := []byte("G0 X1 Y1\nG1 X2 Y2\n")
+	if err := c.RunProgram("ir.nc", data); err != nil {
+		t.Fatalf("RunProgram failed: %v", err)
+	}
+	p, _ := s.Program()
+	if p.Type != "Laser" || !snappy.HasHeader(p.Data) || !bytes.HasSuffix(p.Data, data) {
+		t.Errorf("got program %q (%s):\n%s", p.Name, p.Type, p.Data)
+	}
+	if !bytes.Contains(p.Data, []byte(";tool_head: 2W Laser Module\n")) {
+		t.Errorf("header does not name the IR module:\n%s", p.Data)
+	}
+}
@@ -0,0 +1,105 @@
+package snappy_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"zappem.net/pub/net/snappy"
+)
+
+func TestLaserErrors(t *testing.T) {
+	if errs := snappy.LaserErrors(0); len(errs) != 0 {
+		t.Errorf("state 0 got %v", errs)
+	}
+	errs := snappy.LaserErrors(1<<0 | 1<<3 | 1<<5)
+	if len(errs) != 3 || errs[0] != snappy.ErrLaserOverheated || errs[1] != snappy.ErrLaserLocked {
+		t.Errorf("got %v", errs)
+	}
+	if errs := snappy.LaserErrors(1 << 9); len(errs) != 1 || errs[0].Error() != "laser error bit 9" {
+		t.Errorf("unnamed bit got %v", errs)
+	}
+	if errs := snappy.LaserErrors(-1); len(errs) != strconv.IntSize || errs[3] != snappy.ErrLaserLocked {
+		t.Errorf("negative state got %d errors: %v", len(errs), errs)
+	}
+	err := snappy.StatusResult{Laser10WErrorState: 2}.LaserError()
+	if !errors.Is(err, snappy.ErrLaserTilted) || errors.Is(err, snappy.ErrLaserLocked) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestLaser10W(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+	if max := c.MaxSpotPower(); max != 1.5 {
+		t.Errorf("1.6W MaxSpotPower got %g want 1.5", max)
+	}
+	s.SetToolHead(14)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	max := c.MaxSpotPower()
+	if max >= 1 {
+		t.Fatalf("10W MaxSpotPower got %g", max)
+	}
+	if err := c.LaserSpot(ctx, max); err != nil {
+		t.Fatalf("LaserSpot(%g) failed: %v", max, err)
+	}
+	codes := s.Codes()
+	if got, want := codes[len(codes)-1], "M3 P0.24 S0.61"; got != want {
+		t.Errorf("LaserSpot(%g) sent %q want %q", max, got, want)
+	}
+	if st := s.State(); st.LaserPower != max {
+		t.Errorf("laser power %g want %g", st.LaserPower, max)
+	}
+
+	if th, err := c.Tool(); err != nil || !th.SafetyLock {
+		t.Errorf("10W Tool got %+v, %v, want a SafetyLock", th, err)
+	}
+
+	// A locked laser is refused, not unlocked.
+	if err := c.LaserSpot(ctx, 0); err != nil {
+		t.Fatalf("LaserSpot(0) failed: %v", err)
+	}
+	s.SetLaserError(1 << 3)
+	sent := len(s.Codes())
+	if err := c.LaserSpot(ctx, max); !errors.Is(err, snappy.ErrLaserLocked) {
+		t.Errorf("LaserSpot with locked laser got %v, want %v", err, snappy.ErrLaserLocked)
+	}
+	if err := c.UploadProgram(ctx, "job.nc", []byte("G0 X1\n")); err != nil {
+		t.Fatalf("UploadProgram failed: %v", err)
+	}
+	if err := c.StartPreparedProgram(ctx); !errors.Is(err, snappy.ErrLaserLocked) {
+		t.Errorf("StartPreparedProgram with locked laser got %v, want %v", err, snappy.ErrLaserLocked)
+	}
+	if codes := s.Codes(); len(codes) != sent {
+		t.Errorf("locked laser sent codes %q", codes[sent:])
+	}
+	if st := s.State(); st.Laser10WErrorState != 1<<3 || st.LaserPower != 0 {
+		t.Errorf("locked laser changed: error state %d, power %g", st.Laser10WErrorState, st.LaserPower)
+	}
+
+	s.SetLaserError(1<<0 | 1<<3)
+	if err := c.LaserSpot(ctx, max); !errors.Is(err, snappy.ErrLaserOverheated) {
+		t.Errorf("LaserSpot of overheated laser got %v", err)
+	}
+	s.SetLaserError(0)
+
+	if err := c.AirAssist(ctx, true); err != nil || !s.AirAssist() {
+		t.Fatalf("AirAssist(true) got %v, on=%v", err, s.AirAssist())
+	}
+	if err := c.AirAssist(ctx, false); err != nil || s.AirAssist() {
+		t.Fatalf("AirAssist(false) got %v, on=%v", err, s.AirAssist())
+	}
+	if _, err := c.SnapAtJPEG(ctx, 0, 10, 10, 10); err != nil {
+		t.Errorf("10W SnapAtJPEG failed: %v", err)
+	}
+
+	s.SetToolHead(1)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if err := c.AirAssist(ctx, true); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("CNC AirAssist got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package snappy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrOutOfBounds is returned, wrapped with a description of the
+// problem, when a move would take the tool head outside its Limits.
+var ErrOutOfBounds = errors.New("out of bounds")
+
+// Box is a rectangular region of machine coordinates in mm.
+type Box struct {
+	Min, Max [3]float64
+}
+
+// Contains indicates p is inside, or on the surface of, b.
+func (b Box) Contains(p [3]float64) bool {
+	for i := range p {
+		if p[i] < b.Min[i] || p[i] > b.Max[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Intersects indicates the straight line from p0 to p1 passes
+// through b.
+func (b Box) Intersects(p0, p1 [3]float64) bool {
+	t0, t1 := 0.0, 1.0
+	for i := range p0 {
+		d := p1[i] - p0[i]
+		if d == 0 {
+			if p0[i] < b.Min[i] || p0[i] > b.Max[i] {
+				return false
+			}
+			continue
+		}
+		lo, hi := (b.Min[i]-p0[i])/d, (b.Max[i]-p0[i])/d
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		t0, t1 = math.Max(t0, lo), math.Min(t1, hi)
+		if t0 > t1 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b Box) String() string {
+	return fmt.Sprintf("(%.2f,%.2f,%.2f)-(%.2f,%.2f,%.2f)", b.Min[0], b.Min[1], b.Min[2], b.Max[0], b.Max[1], b.Max[2])
+}
+
+// Limits constrains where the motion methods of Conn may move the
+// tool head. All values are machine coordinates, which are the work
+// coordinates less the work offsets (StatusResult.OffsetX etc).
+type Limits struct {
+	// Travel holds the reachable machine coordinates.
+	Travel Box
+
+	// KeepOut holds regions, such as clamps or tall parts of a
+	// work piece, that the tool head must not pass through.
+	KeepOut []Box
+
+	// MinZ is the lowest machine Z coordinate the tool head may
+	// be moved to.
+	MinZ float64
+}
+
+// Limits returns the default limits for the model: its full travel.
+func (m MachineModel) Limits() Limits {
+	return Limits{Travel: Box{Max: m.Travel}}
+}
+
+// Validate confirms the boxes of l are well formed.
+func (l Limits) Validate() error {
+	for i, b := range append([]Box{l.Travel}, l.KeepOut...) {
+		for j := range b.Min {
+			if b.Min[j] > b.Max[j] {
+				return fmt.Errorf("box[%d] %v is inverted: %w", i, b, ErrInvalid)
+			}
+		}
+	}
+	return nil
+}
+
+// Check confirms the straight move from machine coordinate p0 to p1
+// stays within l. The starting point is not itself checked, so a
+// tool head found outside the limits can be moved back inside them.
+func (l Limits) Check(p0, p1 [3]float64) error {
+	if !l.Travel.Contains(p1) {
+		return fmt.Errorf("%w: machine (%.2f,%.2f,%.2f) outside travel %v", ErrOutOfBounds, p1[0], p1[1], p1[2], l.Travel)
+	}
+	if p1[2] < l.MinZ {
+		return fmt.Errorf("%w: machine z=%.2f below minimum z=%.2f", ErrOutOfBounds, p1[2], l.MinZ)
+	}
+	for i, b := range l.KeepOut {
+		if b.Intersects(p0, p1) {
+			return fmt.Errorf("%w: move (%.2f,%.2f,%.2f) to (%.2f,%.2f,%.2f) enters keep-out[%d] %v", ErrOutOfBounds, p0[0], p0[1], p0[2], p1[0], p1[1], p1[2], i, b)
+		}
+	}
+	return nil
+}
+
+// SetLimits replaces the limits checked by the motion methods. The
+// limits default to those of the Model.
+func (c *Conn) SetLimits(l Limits) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	l.KeepOut = append([]Box(nil), l.KeepOut...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limits = l
+	return nil
+}
+
+// Limits returns the limits checked by the motion methods.
+func (c *Conn) Limits() Limits {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.limits
+	l.KeepOut = append([]Box(nil), l.KeepOut...)
+	return l
+}
+
+// checkPath confirms the straight moves from the current location
+// through each of the work coordinate points stay within the limits.
+func (c *Conn) checkPath(points ...[3]float64) error {
+	c.mu.Lock()
+	st, l := c.toolState, c.limits
+	c.mu.Unlock()
+	off := [3]float64{st.OffsetX, st.OffsetY, st.OffsetZ}
+	from := [3]float64{st.X - off[0], st.Y - off[1], st.Z - off[2]}
+	for _, p := range points {
+		to := [3]float64{p[0] - off[0], p[1] - off[1], p[2] - off[2]}
+		if err := l.Check(from, to); err != nil {
+			return err
+		}
+		from = to
+	}
+	return nil
+}
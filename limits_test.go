@@ -0,0 +1,95 @@
+package snappy_test
+
+import (
+	"errors"
+	"testing"
+
+	"zappem.net/pub/net/snappy"
+)
+
+func TestBox(t *testing.T) {
+	b := snappy.Box{Min: [3]float64{10, 10, 0}, Max: [3]float64{20, 20, 5}}
+	vs := []struct {
+		p0, p1 [3]float64
+		want   bool
+	}{
+		{[3]float64{0, 0, 1}, [3]float64{5, 5, 1}, false},
+		{[3]float64{0, 15, 1}, [3]float64{30, 15, 1}, true},
+		{[3]float64{0, 0, 1}, [3]float64{30, 30, 1}, true},
+		{[3]float64{0, 0, 10}, [3]float64{30, 30, 10}, false},
+		{[3]float64{15, 15, 10}, [3]float64{15, 15, 2}, true},
+		{[3]float64{0, 30, 1}, [3]float64{30, 21, 1}, false},
+	}
+	for i, v := range vs {
+		if got := b.Intersects(v.p0, v.p1); got != v.want {
+			t.Errorf("[%d] %v.Intersects(%v, %v) = %v want %v", i, b, v.p0, v.p1, got, v.want)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+	if l := c.Limits(); l.Travel.Max != c.Model().Travel {
+		t.Errorf("default limits %v want travel %v", l.Travel, c.Model().Travel)
+	}
+	if err := c.SetLimits(snappy.Limits{Travel: snappy.Box{Min: [3]float64{10}}}); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("inverted SetLimits got %v", err)
+	}
+
+	if err := c.MoveTo(ctx, 100, 100, 50); err != nil {
+		t.Fatalf("MoveTo failed: %v", err)
+	}
+	if err := c.SetOrigin(ctx); err != nil {
+		t.Fatalf("SetOrigin failed: %v", err)
+	}
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	sent := len(s.Codes())
+	for _, p := range [][3]float64{{-101, 0, 0}, {0, 251, 0}, {0, 0, -51}} {
+		if err := c.MoveTo(ctx, p[0], p[1], p[2]); !errors.Is(err, snappy.ErrOutOfBounds) {
+			t.Errorf("MoveTo%v got %v want %v", p, err, snappy.ErrOutOfBounds)
+		}
+	}
+	if err := c.Step(ctx, 0, 0, -50.5); !errors.Is(err, snappy.ErrOutOfBounds) {
+		t.Errorf("Step below travel got %v", err)
+	}
+
+	l := c.Model().Limits()
+	l.MinZ = 20
+	l.KeepOut = []snappy.Box{{Min: [3]float64{140, 90, 0}, Max: [3]float64{160, 110, 55}}}
+	if err := c.SetLimits(l); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+	if err := c.Step(ctx, 0, 0, -31); !errors.Is(err, snappy.ErrOutOfBounds) {
+		t.Errorf("Step below MinZ got %v", err)
+	}
+	if err := c.MoveTo(ctx, 80, 0, 0); !errors.Is(err, snappy.ErrOutOfBounds) {
+		t.Errorf("MoveTo through keep-out got %v", err)
+	}
+	if got := len(s.Codes()); got != sent {
+		t.Errorf("rejected moves sent %d codes", got-sent)
+	}
+
+	if err := c.MoveTo(ctx, 80, 0, 20); err != nil {
+		t.Fatalf("MoveTo over keep-out failed: %v", err)
+	}
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	// The XY move back to the origin passes through the keep-out.
+	if err := c.Step(ctx, 0, 0, -20); err != nil {
+		t.Fatalf("Step failed: %v", err)
+	}
+	if err := c.GoToOrigin(ctx); !errors.Is(err, snappy.ErrOutOfBounds) {
+		t.Errorf("GoToOrigin through keep-out got %v", err)
+	}
+	l.KeepOut = nil
+	if err := c.SetLimits(l); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+	if err := c.GoToOrigin(ctx); err != nil {
+		t.Errorf("GoToOrigin failed: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package snappy
+
+import "fmt"
+
+// MachineModel describes the physical characteristics of a model of
+// Snapmaker 2.0 machine. All dimensions are in mm.
+type MachineModel struct {
+	// Name is the short name of the model, for example "A350".
+	Name string
+
+	// Series holds the series strings the model reports when a
+	// connection is made.
+	Series []string
+
+	// WorkVolume is the (x,y,z) size of the work space.
+	WorkVolume [3]float64
+
+	// Travel is the (x,y,z) extent of the tool head travel in
+	// machine coordinates, each axis ranging from 0 to this value.
+	Travel [3]float64
+
+	// MaxFeed is the fastest feed rate, in mm/minute, of each of
+	// the (x,y,z) axes.
+	MaxFeed [3]float64
+
+	// Park is the machine coordinate location that gives the
+	// most convenient access for changing the tool head.
+	Park [3]float64
+
+	// Modules holds the ModuleNames IDs of the modules the model
+	// supports.
+	Modules []int
+}
+
+// MachineModels lists the known Snapmaker 2.0 models. Only the A350
+// dimensions have been confirmed against a real machine; the others
+// are derived from the published work volumes. The MaxFeed values
+// are conservative estimates for all models.
+var MachineModels = []MachineModel{
+	{
+		Name:       "A150",
+		Series:     []string{"Snapmaker 2.0 A150", "Snapmaker 2.0 A150T"},
+		WorkVolume: [3]float64{160, 160, 145},
+		Travel:     [3]float64{160, 160, 145},
+		MaxFeed:    [3]float64{6000, 6000, 3000},
+		Park:       [3]float64{82, 150, 70},
+		Modules:    []int{0, 1, 2, 14, 18, 23, 519},
+	},
+	{
+		Name:       "A250",
+		Series:     []string{"Snapmaker 2.0 A250", "Snapmaker 2.0 A250T"},
+		WorkVolume: [3]float64{230, 250, 235},
+		Travel:     [3]float64{230, 250, 235},
+		MaxFeed:    [3]float64{6000, 6000, 3000},
+		Park:       [3]float64{128, 234, 112},
+		Modules:    []int{0, 1, 2, 14, 15, 18, 23, 519, 522},
+	},
+	{
+		Name:       "A350",
+		Series:     []string{"Snapmaker 2.0 A350", "Snapmaker 2.0 A350T"},
+		WorkVolume: [3]float64{320, 350, 330},
+		Travel:     [3]float64{320, 350, 330},
+		MaxFeed:    [3]float64{6000, 6000, 3000},
+		Park:       [3]float64{179, 327, 156.5},
+		Modules:    []int{0, 1, 2, 14, 15, 18, 23, 519, 522},
+	},
+	{
+		Name:       "F250",
+		Series:     []string{"Snapmaker 2.0 F250"},
+		WorkVolume: [3]float64{230, 250, 235},
+		Travel:     [3]float64{230, 250, 235},
+		MaxFeed:    [3]float64{6000, 6000, 3000},
+		Park:       [3]float64{128, 234, 112},
+		Modules:    []int{0, 1, 2, 14, 23},
+	},
+	{
+		Name:       "F350",
+		Series:     []string{"Snapmaker 2.0 F350"},
+		WorkVolume: [3]float64{320, 350, 330},
+		Travel:     [3]float64{320, 350, 330},
+		MaxFeed:    [3]float64{6000, 6000, 3000},
+		Park:       [3]float64{179, 327, 156.5},
+		Modules:    []int{0, 1, 2, 14, 23},
+	},
+}
+
+// ModelBySeries returns the model that reports series.
+func ModelBySeries(series string) (MachineModel, error) {
+	for _, m := range MachineModels {
+		for _, s := range m.Series {
+			if s == series {
+				return m, nil
+			}
+		}
+	}
+	return MachineModel{}, fmt.Errorf("unsupported series %q", series)
+}
+
+// ModelByName returns the model with the short name, for example
+// "A250".
+func ModelByName(name string) (MachineModel, error) {
+	for _, m := range MachineModels {
+		if m.Name == name {
+			return m, nil
+		}
+	}
+	return MachineModel{}, fmt.Errorf("unknown model %q", name)
+}
+
+// Supports indicates the model supports the module with the
+// ModuleNames id.
+func (m MachineModel) Supports(id int) bool {
+	for _, mod := range m.Modules {
+		if mod == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Model returns the model of the connected machine.
+func (c *Conn) Model() MachineModel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.model
+}
@@ -0,0 +1,40 @@
+package snappy_test
+
+import (
+	"context"
+	"testing"
+
+	"zappem.net/pub/net/snappy"
+	"zappem.net/pub/net/snappy/snappytest"
+)
+
+func TestModels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := snappytest.NewServer(testToken)
+	defer s.Close()
+	for _, series := range []string{"Snapmaker 2.0 A150", "Snapmaker 2.0 A250T", "Snapmaker 2.0 A350", "Snapmaker 2.0 F350"} {
+		s.SetSeries(series)
+		c, err := snappy.NewConn(ctx, s.Addr(), testToken)
+		if err != nil {
+			t.Fatalf("NewConn(%q) failed: %v", series, err)
+		}
+		m := c.Model()
+		if want := series[len("Snapmaker 2.0 "):][:4]; m.Name != want {
+			t.Errorf("%q got model %q want %q", series, m.Name, want)
+		}
+		for i, p := range m.Park {
+			if p <= 0 || p > m.Travel[i] {
+				t.Errorf("%s park %v outside travel %v", m.Name, m.Park, m.Travel)
+			}
+		}
+		c.Close()
+	}
+	s.SetSeries("Snapmaker Artisan")
+	if _, err := snappy.NewConn(ctx, s.Addr(), testToken); err == nil {
+		t.Error("connected to unsupported series")
+	}
+	if m, err := snappy.ModelByName("A250"); err != nil || !m.Supports(15) || m.Supports(99) {
+		t.Errorf("A250 got %v, %v", m, err)
+	}
+}
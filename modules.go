@@ -0,0 +1,239 @@
+package snappy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// ModuleType is implemented by the decoded details of each kind of
+// module. See RegisterModuleDecoder.
+type ModuleType interface {
+	MarshalJSON() ([]byte, error)
+}
+
+// ModuleDetail holds the details of a single keyed module from a
+// module_info response.
+type ModuleDetail struct {
+	Key    int `json:"key"`
+	Module ModuleType
+}
+
+type ModuleLaser struct {
+	LaserFocalLength float64 `json:"laserFocalLength"`
+	LaserPower       float64 `json:"laserPower"`
+	LaserCamera      bool    `json:"laserCamera"`
+}
+
+func (ml *ModuleLaser) MarshalJSON() ([]byte, error) {
+	type plain ModuleLaser
+	return json.Marshal((*plain)(ml))
+}
+
+type ModuleEnclosure struct {
+	IsReady             bool `json:"isReady"`
+	LED                 int  `json:"led"`
+	Fan                 int  `json:"fan"`
+	IsDoorEnabled       bool `json:"isDoorEnabled"`
+	IsEnclosureDoorOpen bool `json:"isEnclosureDoorOpen"`
+	DoorSwitchCount     int  `json:"doorSwitchCount"`
+}
+
+func (me *ModuleEnclosure) MarshalJSON() ([]byte, error) {
+	type plain ModuleEnclosure
+	return json.Marshal((*plain)(me))
+}
+
+type ModuleEmergencyStop struct {
+	IsEmergencyStopped bool `json:"isEmergencyStopped"`
+}
+
+func (mes *ModuleEmergencyStop) MarshalJSON() ([]byte, error) {
+	type plain ModuleEmergencyStop
+	return json.Marshal((*plain)(mes))
+}
+
+type ModuleQuickSwap struct {
+	QuickSwapState int `json:"quickSwapState"`
+	QuickSwapType  int `json:"quickSwapType"`
+}
+
+func (mq *ModuleQuickSwap) MarshalJSON() ([]byte, error) {
+	type plain ModuleQuickSwap
+	return json.Marshal((*plain)(mq))
+}
+
+type ModuleBracingKit struct {
+	BracingKitState int `json:"bracingKitState"`
+}
+
+func (mb *ModuleBracingKit) MarshalJSON() ([]byte, error) {
+	type plain ModuleBracingKit
+	return json.Marshal((*plain)(mb))
+}
+
+type Module3DBasic struct {
+	NozzleTemperature       float64 `json:"nozzleTemperature"`
+	NozzleTargetTemperature float64 `json:"nozzleTargetTemperature"`
+	IsFilamentOut           bool    `json:"isFilamentOut"`
+}
+
+func (m3 *Module3DBasic) MarshalJSON() ([]byte, error) {
+	type plain Module3DBasic
+	return json.Marshal((*plain)(m3))
+}
+
+type Module3DDual struct {
+	NozzleTemperature1       float64 `json:"nozzleTemperature1"`
+	NozzleTargetTemperature1 float64 `json:"nozzleTargetTemperature1"`
+	NozzleTemperature2       float64 `json:"nozzleTemperature2"`
+	NozzleTargetTemperature2 float64 `json:"nozzleTargetTemperature2"`
+	IsFilamentOut            bool    `json:"isFilamentOut"`
+}
+
+func (m3 *Module3DDual) MarshalJSON() ([]byte, error) {
+	type plain Module3DDual
+	return json.Marshal((*plain)(m3))
+}
+
+type ModuleCNC struct {
+	SpindleSpeed int `json:"spindleSpeed"`
+}
+
+func (mc *ModuleCNC) MarshalJSON() ([]byte, error) {
+	type plain ModuleCNC
+	return json.Marshal((*plain)(mc))
+}
+
+// ModuleCNC200W holds the details reported by the 200W CNC tool
+// head. The fields beyond those of ModuleCNC have not been confirmed
+// against a real 200W head.
+type ModuleCNC200W struct {
+	SpindleSpeed       int     `json:"spindleSpeed"`
+	SpindleTargetSpeed int     `json:"spindleTargetSpeed"`
+	SpindleClockwise   bool    `json:"spindleClockwise"`
+	SpindleTemperature float64 `json:"spindleTemperature"`
+}
+
+func (mc *ModuleCNC200W) MarshalJSON() ([]byte, error) {
+	type plain ModuleCNC200W
+	return json.Marshal((*plain)(mc))
+}
+
+func (m ModuleDetail) String() string {
+	j, err := m.MarshalJSON()
+	if err != nil {
+		return fmt.Sprintf("error:%v", err)
+	}
+	return string(j)
+}
+
+// MarshalJSON generates the module_info form of the detail: the
+// fields of the Module with an added "key" field.
+func (m ModuleDetail) MarshalJSON() ([]byte, error) {
+	key := fmt.Sprintf(`{"key":%d`, m.Key)
+	if m.Module == nil {
+		return []byte(key + "}"), nil
+	}
+	b, err := m.Module.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) < 2 || b[0] != '{' || b[len(b)-1] != '}' {
+		return nil, fmt.Errorf("key=%d module is not a JSON object: %q", m.Key, b)
+	}
+	if body := bytes.TrimSpace(b[1 : len(b)-1]); len(body) != 0 {
+		key += "," + string(body)
+	}
+	return []byte(key + "}"), nil
+}
+
+// ModuleRaw holds the fields of a module_info entry that no
+// registered decoder recognized. The "key" field is not included.
+type ModuleRaw map[string]json.RawMessage
+
+func (mr ModuleRaw) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]json.RawMessage(mr))
+}
+
+// moduleDecoder associates a probe field with a ModuleType factory.
+type moduleDecoder struct {
+	probe   string
+	factory func() ModuleType
+}
+
+var (
+	decoderMu sync.RWMutex
+	decoders  []moduleDecoder
+)
+
+// RegisterModuleDecoder arranges for module_info entries that
+// contain the probeField key to be decoded into the (pointer)
+// ModuleType returned by factory. When an entry contains the probe
+// fields of more than one decoder, the most recently registered
+// decoder is used, so registrations can override the built-in
+// decoders.
+func RegisterModuleDecoder(probeField string, factory func() ModuleType) {
+	decoderMu.Lock()
+	defer decoderMu.Unlock()
+	decoders = append(decoders, moduleDecoder{probe: probeField, factory: factory})
+}
+
+func init() {
+	RegisterModuleDecoder("laserPower", func() ModuleType { return &ModuleLaser{} })
+	RegisterModuleDecoder("isEnclosureDoorOpen", func() ModuleType { return &ModuleEnclosure{} })
+	RegisterModuleDecoder("isEmergencyStopped", func() ModuleType { return &ModuleEmergencyStop{} })
+	RegisterModuleDecoder("quickSwapState", func() ModuleType { return &ModuleQuickSwap{} })
+	RegisterModuleDecoder("bracingKitState", func() ModuleType { return &ModuleBracingKit{} })
+	RegisterModuleDecoder("spindleSpeed", func() ModuleType { return &ModuleCNC{} })
+	RegisterModuleDecoder("spindleTargetSpeed", func() ModuleType { return &ModuleCNC200W{} })
+	RegisterModuleDecoder("nozzleTargetTemperature", func() ModuleType { return &Module3DBasic{} })
+	RegisterModuleDecoder("nozzleTargetTemperature2", func() ModuleType { return &Module3DDual{} })
+}
+
+// decoderFor returns the factory of the decoder that recognizes
+// fields, or nil.
+func decoderFor(fields map[string]json.RawMessage) func() ModuleType {
+	decoderMu.RLock()
+	defer decoderMu.RUnlock()
+	for i := len(decoders) - 1; i >= 0; i-- {
+		if _, ok := fields[decoders[i].probe]; ok {
+			return decoders[i].factory
+		}
+	}
+	return nil
+}
+
+func (m *ModuleDetail) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	key, ok := fields["key"]
+	if !ok {
+		return ErrNoKey
+	}
+	k, err := strconv.Atoi(string(key))
+	if err != nil {
+		return fmt.Errorf("bad key %s: %v", key, err)
+	}
+	if k < 0 {
+		return ErrNoKey
+	}
+	delete(fields, "key")
+	m.Key = k
+	factory := decoderFor(fields)
+	if factory == nil {
+		m.Module = ModuleRaw(fields)
+		return nil
+	}
+	mod := factory()
+	if err := json.Unmarshal(b, mod); err != nil {
+		return err
+	}
+	m.Module = mod
+	return nil
+}
@@ -0,0 +1,124 @@
+package snappy_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"zappem.net/pub/net/snappy"
+)
+
+// ModuleRotary is a module type registered by this test.
+type ModuleRotary struct {
+	RotaryAngle float64 `json:"rotaryAngle"`
+}
+
+func (mr *ModuleRotary) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{"rotaryAngle":%g}`, mr.RotaryAngle)), nil
+}
+
+func TestModuleDecode(t *testing.T) {
+	snappy.RegisterModuleDecoder("rotaryAngle", func() snappy.ModuleType { return &ModuleRotary{} })
+	const payload = `{"moduleInfo":[
+{"laserPower":1.5,"key":1,"laserFocalLength":20.5,"laserCamera":true},
+{"key":2,"nozzleTemperature1":200,"nozzleTargetTemperature1":210,"nozzleTemperature2":25,"nozzleTargetTemperature2":0,"isFilamentOut":false},
+{"key":3,"nozzleTemperature":195,"nozzleTargetTemperature":200,"isFilamentOut":true},
+{"key":4,"rotaryAngle":90},
+{"key":5,"airPurifierFanSpeed":2,"airPurifierFilterLife":80}
+]}`
+	var mr snappy.ModResult
+	if err := json.Unmarshal([]byte(payload), &mr); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(mr.ModuleInfo) != 5 {
+		t.Fatalf("got %d modules, want 5", len(mr.ModuleInfo))
+	}
+	for i, m := range mr.ModuleInfo {
+		if m.Key != i+1 {
+			t.Errorf("[%d] got key=%d", i, m.Key)
+		}
+	}
+	if ml, ok := mr.ModuleInfo[0].Module.(*snappy.ModuleLaser); !ok || ml.LaserPower != 1.5 || ml.LaserFocalLength != 20.5 || !ml.LaserCamera {
+		t.Errorf("[0] got %#v", mr.ModuleInfo[0].Module)
+	}
+	if md, ok := mr.ModuleInfo[1].Module.(*snappy.Module3DDual); !ok || md.NozzleTargetTemperature1 != 210 {
+		t.Errorf("[1] got %#v", mr.ModuleInfo[1].Module)
+	}
+	if mb, ok := mr.ModuleInfo[2].Module.(*snappy.Module3DBasic); !ok || !mb.IsFilamentOut {
+		t.Errorf("[2] got %#v", mr.ModuleInfo[2].Module)
+	}
+	if rot, ok := mr.ModuleInfo[3].Module.(*ModuleRotary); !ok || rot.RotaryAngle != 90 {
+		t.Errorf("[3] got %#v", mr.ModuleInfo[3].Module)
+	}
+	raw, ok := mr.ModuleInfo[4].Module.(snappy.ModuleRaw)
+	if !ok || string(raw["airPurifierFilterLife"]) != "80" {
+		t.Errorf("[4] got %#v", mr.ModuleInfo[4].Module)
+	}
+
+	for i, bad := range []string{`{"laserPower":1}`, `{"key":-1}`, `{"key":"one"}`, `[1]`} {
+		var md snappy.ModuleDetail
+		if err := json.Unmarshal([]byte(bad), &md); err == nil {
+			t.Errorf("[%d] decoded %q", i, bad)
+		}
+	}
+}
+
+// TestModuleRoundTrip checks that the module_info payloads in
+// testdata/module_info decode and re-encode to equivalent JSON, and
+// that the re-encoded form decodes to the same details.
+func TestModuleRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "module_info", "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no golden files: %v", err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			golden, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var mr snappy.ModResult
+			if err := json.Unmarshal(golden, &mr); err != nil {
+				t.Fatalf("decode failed: %v", err)
+			}
+			b, err := json.Marshal(mr)
+			if err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+			var want, got any
+			if err := json.Unmarshal(golden, &want); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("encoded invalid JSON %s: %v", b, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip mismatch:\n got %s\nwant %s", b, golden)
+			}
+			var again snappy.ModResult
+			if err := json.Unmarshal(b, &again); err != nil {
+				t.Fatalf("re-decode failed: %v", err)
+			}
+			if !reflect.DeepEqual(again, mr) {
+				t.Errorf("re-decode mismatch: got %v want %v", again.ModuleInfo, mr.ModuleInfo)
+			}
+		})
+	}
+
+	for _, m := range []snappy.ModuleType{
+		&snappy.ModuleLaser{}, &snappy.ModuleEnclosure{}, &snappy.ModuleEmergencyStop{},
+		&snappy.ModuleQuickSwap{}, &snappy.ModuleBracingKit{}, &snappy.Module3DBasic{},
+		&snappy.Module3DDual{}, &snappy.ModuleCNC{}, &snappy.ModuleCNC200W{}, snappy.ModuleRaw{},
+	} {
+		md := snappy.ModuleDetail{Key: 7, Module: m}
+		if b, err := json.Marshal(md); err != nil || !json.Valid(b) {
+			t.Errorf("%T marshaled to %q, %v", m, b, err)
+		}
+	}
+	if s := (snappy.ModuleDetail{Key: 1}).String(); s != `{"key":1}` {
+		t.Errorf("empty detail got %s", s)
+	}
+}
@@ -0,0 +1,115 @@
+package snappy
+
+import (
+	"fmt"
+	"math"
+)
+
+// MotionProfile holds the feed rates, in mm/minute, of the motion
+// methods of Conn. Moves that lower the tool head use Plunge. Other
+// moves use Travel, except for Step which uses Jog.
+type MotionProfile struct {
+	Travel float64
+	Plunge float64
+	Jog    float64
+}
+
+// DefaultMotionProfile is the initial MotionProfile of a Conn.
+var DefaultMotionProfile = MotionProfile{
+	Travel: 1500,
+	Plunge: 1500,
+	Jog:    1500,
+}
+
+// merge returns p with its unset (zero) feeds taken from base.
+func (p MotionProfile) merge(base MotionProfile) MotionProfile {
+	if p.Travel == 0 {
+		p.Travel = base.Travel
+	}
+	if p.Plunge == 0 {
+		p.Plunge = base.Plunge
+	}
+	if p.Jog == 0 {
+		p.Jog = base.Jog
+	}
+	return p
+}
+
+// Validate confirms the feeds of p are positive and no faster than
+// the maximum feeds of the model. Plunge is limited by the Z axis
+// maximum, while Travel and Jog are limited by the X and Y axes.
+func (p MotionProfile) Validate(m MachineModel) error {
+	xy := math.Min(m.MaxFeed[0], m.MaxFeed[1])
+	for _, f := range []struct {
+		name      string
+		feed, max float64
+	}{
+		{"travel", p.Travel, xy},
+		{"plunge", p.Plunge, m.MaxFeed[2]},
+		{"jog", p.Jog, xy},
+	} {
+		if f.feed <= 0 || f.feed > f.max {
+			return fmt.Errorf("%s feed %g outside (0,%g] of %s: %w", f.name, f.feed, f.max, m.Name, ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// MoveOption adjusts a single call of MoveTo, Step or GoToOrigin.
+// See WithProfile, WithFeed and WithDirectMove.
+type MoveOption func(*moveOptions)
+
+// moveOptions holds the adjustments made by MoveOptions.
+type moveOptions struct {
+	profile MotionProfile
+	direct  bool
+}
+
+// WithProfile overrides the feeds of the Conn's MotionProfile for one
+// call. Zero fields of p are not overridden.
+func WithProfile(p MotionProfile) MoveOption {
+	return func(o *moveOptions) {
+		o.profile = p.merge(o.profile)
+	}
+}
+
+// WithFeed overrides all of the feeds of the Conn's MotionProfile
+// with feed for one call.
+func WithFeed(feed float64) MoveOption {
+	return WithProfile(MotionProfile{Travel: feed, Plunge: feed, Jog: feed})
+}
+
+// moveOptions returns the validated options for a motion call.
+func (c *Conn) moveOptions(opts []MoveOption) (moveOptions, error) {
+	c.mu.Lock()
+	o := moveOptions{profile: c.profile}
+	m := c.model
+	c.mu.Unlock()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.profile.Validate(m); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// SetMotionProfile replaces the feeds used by the motion methods.
+// Zero fields of p keep their current values.
+func (c *Conn) SetMotionProfile(p MotionProfile) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p = p.merge(c.profile)
+	if err := p.Validate(c.model); err != nil {
+		return err
+	}
+	c.profile = p
+	return nil
+}
+
+// MotionProfile returns the feeds used by the motion methods.
+func (c *Conn) MotionProfile() MotionProfile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.profile
+}
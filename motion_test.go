@@ -0,0 +1,86 @@
+package snappy_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"zappem.net/pub/net/snappy"
+)
+
+func TestMotionProfile(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+	if p := c.MotionProfile(); p != snappy.DefaultMotionProfile {
+		t.Errorf("got profile %+v want %+v", p, snappy.DefaultMotionProfile)
+	}
+	for _, bad := range []snappy.MotionProfile{{Plunge: 5000}, {Travel: -1}, {Jog: 7000}} {
+		if err := c.SetMotionProfile(bad); !errors.Is(err, snappy.ErrInvalid) {
+			t.Errorf("SetMotionProfile(%+v) got %v", bad, err)
+		}
+	}
+	if err := c.SetMotionProfile(snappy.MotionProfile{Travel: 3000, Plunge: 500, Jog: 1000}); err != nil {
+		t.Fatalf("SetMotionProfile failed: %v", err)
+	}
+
+	feeds := []struct {
+		name string
+		move func() error
+		want float64
+	}{
+		{"up", func() error { return c.MoveTo(ctx, 10, 10, 30) }, 3000},
+		{"down", func() error { return c.MoveTo(ctx, 10, 10, 20) }, 500},
+		{"override", func() error { return c.MoveTo(ctx, 20, 10, 20, snappy.WithFeed(600)) }, 600},
+		{"partial", func() error {
+			return c.MoveTo(ctx, 20, 10, 10, snappy.WithProfile(snappy.MotionProfile{Travel: 100}))
+		}, 500},
+		{"jog", func() error { return c.Step(ctx, 1, 0, 0) }, 1000},
+		{"jog down", func() error { return c.Step(ctx, 0, 0, -1) }, 500},
+		{"origin", func() error { return c.GoToOrigin(ctx, snappy.WithFeed(2000)) }, 2000},
+	}
+	for _, f := range feeds {
+		if err := f.move(); err != nil {
+			t.Fatalf("%s move failed: %v", f.name, err)
+		}
+		if got := s.Feed(); got != f.want {
+			t.Errorf("%s move feed got %g want %g", f.name, got, f.want)
+		}
+	}
+
+	// A step down and across only descends at the Plunge feed.
+	if err := c.MoveTo(ctx, 10, 10, 10); err != nil {
+		t.Fatalf("MoveTo failed: %v", err)
+	}
+	sent := len(s.Codes())
+	if err := c.Step(ctx, 2, 3, -1); err != nil {
+		t.Fatalf("Step across and down failed: %v", err)
+	}
+	want := []string{"G91", "G0 F1000 X2.00 Y3.00 Z0.00", "G0 F500 X0.00 Y0.00 Z-1.00", "G90"}
+	if got := s.Codes()[sent:]; !slices.Equal(got, want) {
+		t.Errorf("Step across and down sent %q want %q", got, want)
+	}
+
+	sent = len(s.Codes())
+	if err := c.MoveTo(ctx, 5, 5, 5, snappy.WithFeed(10000)); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("MoveTo too fast got %v", err)
+	}
+	if got := len(s.Codes()); got != sent {
+		t.Errorf("rejected move sent %d codes", got-sent)
+	}
+
+	// The capture makes the last move of the path, at the Plunge
+	// feed when it descends.
+	for _, v := range []struct {
+		z, want float64
+	}{
+		{3, 500},
+		{c.SafeZ() + 1, 3000},
+	} {
+		if _, err := c.SnapAtJPEG(ctx, 0, 1, 2, v.z); err != nil {
+			t.Fatalf("SnapAtJPEG failed: %v", err)
+		}
+		if got := s.Feed(); got != v.want {
+			t.Errorf("SnapAtJPEG at Z=%g feed got %g want %g", v.z, got, v.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package snappy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// pairPollInterval is how often RequestToken checks whether the
+// connection has been approved on the touchscreen.
+var pairPollInterval = 500 * time.Millisecond
+
+// RequestToken obtains a new connection token from the machine at
+// addr, an IP address with an optional ":port" suffix. The machine
+// asks the user, via its touchscreen, to approve the connection.
+// RequestToken waits for that approval, returning ErrRejected if the
+// user declines. The returned token can be used with NewConn.
+func RequestToken(ctx context.Context, addr string) (string, error) {
+	return RequestTokenWithOptions(ctx, Options{Address: addr})
+}
+
+// RequestTokenWithOptions is RequestToken, using the address and
+// HTTP parameters of opts. The opts.Token value is ignored.
+func RequestTokenWithOptions(ctx context.Context, opts Options) (string, error) {
+	opts.Token = ""
+	c, err := newConn(opts)
+	if err != nil {
+		return "", err
+	}
+	res, err := c.connect(ctx)
+	if err != nil {
+		return "", err
+	}
+	if res.Token == "" {
+		return "", ErrInvalidToken
+	}
+	c.token = res.Token
+	for {
+		approved, err := c.approved(ctx)
+		if err != nil {
+			return "", err
+		}
+		if approved {
+			break
+		}
+		select {
+		case <-time.After(pairPollInterval):
+		case <-ctx.Done():
+			return "", ErrCanceled
+		}
+	}
+	// Release the session so the token is free for NewConn.
+	v := url.Values{}
+	v.Set("token", c.token)
+	resp, err := c.postForm(ctx, "/api/v1/disconnect", v)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return c.token, nil
+}
+
+// approved checks whether the pending token has been approved. The
+// device answers status requests with 204 (No Content) until the user
+// responds.
+func (c *Conn) approved(ctx context.Context) (bool, error) {
+	resp, err := c.get(ctx, fmt.Sprint("/api/v1/status?token=", c.token))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNoContent:
+		return false, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, ErrRejected
+	default:
+		return false, fmt.Errorf("pairing failed: status=%d (%q)", resp.StatusCode, resp.Status)
+	}
+}
@@ -0,0 +1,48 @@
+package snappy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+	"zappem.net/pub/net/snappy/snappytest"
+)
+
+// respond waits for a pairing request and approves or rejects it.
+func respond(s *snappytest.Server, approve bool) {
+	for s.Pending() == "" {
+		time.Sleep(time.Millisecond)
+	}
+	if approve {
+		s.Approve()
+	} else {
+		s.Reject()
+	}
+}
+
+func TestRequestToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s := snappytest.NewServer(testToken)
+	defer s.Close()
+
+	go respond(s, true)
+	token, err := snappy.RequestToken(ctx, s.Addr())
+	if err != nil {
+		t.Fatalf("RequestToken failed: %v", err)
+	}
+	if token == "" || token == testToken || token != s.Token() {
+		t.Fatalf("got token %q, fake accepts %q", token, s.Token())
+	}
+	c, err := snappy.NewConn(ctx, s.Addr(), token)
+	if err != nil {
+		t.Fatalf("NewConn with new token failed: %v", err)
+	}
+	c.Close()
+
+	go respond(s, false)
+	if _, err := snappy.RequestToken(ctx, s.Addr()); err != snappy.ErrRejected {
+		t.Errorf("rejected RequestToken got %v want %v", err, snappy.ErrRejected)
+	}
+}
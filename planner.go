@@ -0,0 +1,114 @@
+package snappy
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// DefaultClearance is the height, in mm, the tool head is kept above
+// the work piece while traveling when the tool head is not
+// recognized. Known tool heads use their ToolHead.Clearance.
+const DefaultClearance = 10
+
+// SetWorkHeight records the height, in mm, of the top of the work
+// piece above the work origin. Absolute moves travel at least the
+// tool head clearance above it. See SafeZ.
+func (c *Conn) SetWorkHeight(height float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workHeight = height
+}
+
+// WorkHeight returns the height recorded by SetWorkHeight.
+func (c *Conn) WorkHeight() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.workHeight
+}
+
+// SetSafeZ fixes the work coordinate Z height at which absolute
+// moves travel, whichever tool head is attached. A height of 0
+// restores the WorkHeight plus the tool head clearance. See SafeZ.
+func (c *Conn) SetSafeZ(z float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.safeZ = z
+}
+
+// SafeZ returns the work coordinate Z height at which absolute moves
+// travel: the height set by SetSafeZ, or otherwise the WorkHeight
+// plus the clearance of the attached tool head.
+func (c *Conn) SafeZ() float64 {
+	c.mu.Lock()
+	z := c.safeZ
+	c.mu.Unlock()
+	if z != 0 {
+		return z
+	}
+	clearance := float64(DefaultClearance)
+	if th, err := c.Tool(); err == nil {
+		clearance = th.Clearance
+	}
+	return c.WorkHeight() + clearance
+}
+
+// WithDirectMove moves the tool head in a straight line for one call
+// of MoveTo or GoToOrigin, instead of traveling at the SafeZ height.
+func WithDirectMove() MoveOption {
+	return func(o *moveOptions) {
+		o.direct = true
+	}
+}
+
+// plan returns the work coordinate points of an absolute move from
+// the current location to p. Unless the move is direct, or only
+// changes Z, the tool head is lifted to at least the SafeZ height,
+// travels in X and Y, and then descends.
+func (c *Conn) plan(p [3]float64, o moveOptions) [][3]float64 {
+	c.mu.Lock()
+	from := [3]float64{c.toolState.X, c.toolState.Y, c.toolState.Z}
+	c.mu.Unlock()
+	if o.direct || (from[0] == p[0] && from[1] == p[1]) {
+		return [][3]float64{p}
+	}
+	z := math.Max(c.SafeZ(), math.Max(from[2], p[2]))
+	var path [][3]float64
+	if z != from[2] {
+		path = append(path, [3]float64{from[0], from[1], z})
+	}
+	path = append(path, [3]float64{p[0], p[1], z})
+	if z != p[2] {
+		path = append(path, p)
+	}
+	return path
+}
+
+// moveAlong moves the tool head through the work coordinate points
+// of path. Lowering moves use the Plunge feed, other moves the
+// Travel feed. The caller has confirmed the path is within the
+// Limits and holds the right to move (see waitToMove).
+func (c *Conn) moveAlong(ctx context.Context, path [][3]float64, o moveOptions) error {
+	for _, p := range path {
+		feed := c.legFeed(p, o)
+		if err := c.doCode(ctx, fmt.Sprintf("G0 F%g X%.2f Y%.2f Z%.2f", feed, p[0], p[1], p[2])); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.toolState.X, c.toolState.Y, c.toolState.Z = p[0], p[1], p[2]
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// legFeed returns the feed rate of a move from the current location
+// to p: the Plunge feed of o if the move lowers the tool, otherwise
+// its Travel feed.
+func (c *Conn) legFeed(p [3]float64, o moveOptions) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p[2] < c.toolState.Z {
+		return o.profile.Plunge
+	}
+	return o.profile.Travel
+}
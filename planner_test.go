@@ -0,0 +1,75 @@
+package snappy_test
+
+import (
+	"reflect"
+	"testing"
+
+	"zappem.net/pub/net/snappy"
+)
+
+func TestPlanner(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+	if z := c.SafeZ(); z != 5 {
+		t.Errorf("laser SafeZ got %g want 5", z)
+	}
+	c.SetWorkHeight(20)
+	if z := c.SafeZ(); z != 25 {
+		t.Errorf("SafeZ got %g want 25", z)
+	}
+
+	moves := []struct {
+		name  string
+		move  func() error
+		codes []string
+	}{
+		{"lift", func() error { return c.MoveTo(ctx, 50, 50, 2) }, []string{
+			"G0 F1500 X0.00 Y0.00 Z25.00",
+			"G0 F1500 X50.00 Y50.00 Z25.00",
+			"G0 F1500 X50.00 Y50.00 Z2.00",
+		}},
+		{"vertical", func() error { return c.MoveTo(ctx, 50, 50, 10) }, []string{
+			"G0 F1500 X50.00 Y50.00 Z10.00",
+		}},
+		{"direct", func() error { return c.MoveTo(ctx, 40, 40, 5, snappy.WithDirectMove()) }, []string{
+			"G0 F1500 X40.00 Y40.00 Z5.00",
+		}},
+		{"high", func() error { return c.MoveTo(ctx, 10, 10, 40) }, []string{
+			"G0 F1500 X40.00 Y40.00 Z40.00",
+			"G0 F1500 X10.00 Y10.00 Z40.00",
+		}},
+		{"origin", func() error { return c.GoToOrigin(ctx) }, []string{
+			"G0 F1500 X0.00 Y0.00 Z40.00",
+			"G0 F1500 X0.00 Y0.00 Z0.00",
+		}},
+	}
+	for _, m := range moves {
+		sent := len(s.Codes())
+		if err := m.move(); err != nil {
+			t.Fatalf("%s move failed: %v", m.name, err)
+		}
+		if got := s.Codes()[sent:]; !reflect.DeepEqual(got, m.codes) {
+			t.Errorf("%s move sent %q want %q", m.name, got, m.codes)
+		}
+	}
+
+	s.SetToolHead(1)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if z := c.SafeZ(); z != 30 {
+		t.Errorf("CNC SafeZ got %g want 30", z)
+	}
+	c.SetSafeZ(40)
+	s.SetToolHead(2)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if z := c.SafeZ(); z != 40 {
+		t.Errorf("laser SafeZ got %g want the set 40", z)
+	}
+	c.SetSafeZ(0)
+	if z := c.SafeZ(); z != 25 {
+		t.Errorf("restored SafeZ got %g want 25", z)
+	}
+}
@@ -0,0 +1,286 @@
+package snappy
+
+import (
+	"context"
+	"log"
+	"maps"
+	"time"
+)
+
+// ConnState is the health of a connection as judged by the
+// background status poller.
+type ConnState int
+
+// ConnConnected etc are the values of ConnState.
+const (
+	// ConnConnected indicates status polls are succeeding.
+	ConnConnected ConnState = iota
+	// ConnLost indicates the consecutive failure threshold of
+	// status polls has been reached.
+	ConnLost
+	// ConnReconnecting indicates a lost connection is being
+	// re-established (see Options.Reconnect).
+	ConnReconnecting
+	// ConnClosed indicates the connection has been closed.
+	ConnClosed
+)
+
+// String returns a name for the connection state.
+func (s ConnState) String() string {
+	switch s {
+	case ConnConnected:
+		return "connected"
+	case ConnLost:
+		return "lost"
+	case ConnReconnecting:
+		return "reconnecting"
+	case ConnClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// setConnState records a new connection state, notifying subscribers
+// of any change. The caller holds c.mu.
+func (c *Conn) setConnState(s ConnState) {
+	if c.connState == s {
+		return
+	}
+	c.connState = s
+	c.publish([]StatusEvent{{
+		Kind:       EventConnection,
+		Time:       time.Now(),
+		Status:     c.toolState,
+		Connection: s,
+	}})
+}
+
+// ConnState returns the current connection state.
+func (c *Conn) ConnState() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connState
+}
+
+// reconnectOnce repeats the connection handshake with the original
+// token and restores the connection details.
+func (c *Conn) reconnectOnce(ctx context.Context) error {
+	res, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	if res.Token != c.token {
+		return ErrInvalidToken
+	}
+	c.mu.Lock()
+	c.readOnly = res.ReadOnly
+	c.headType = res.HeadType
+	c.hasEnclosure = res.HasEnclosure
+	c.mu.Unlock()
+	if err := c.modListing(ctx); err != nil {
+		return err
+	}
+	return c.StatusContext(ctx)
+}
+
+// defaultPollTimeout limits each status poll when Options.Timeout
+// is not set, so an unresponsive device counts as a failed poll.
+const defaultPollTimeout = 10 * time.Second
+
+// pollTimeout returns the limit on the duration of each status poll.
+func (c *Conn) pollTimeout() time.Duration {
+	if c.timeout > 0 {
+		return c.timeout
+	}
+	return defaultPollTimeout
+}
+
+// pollStatus starts the background monitoring of the devices. It
+// returns after one successful status request has been made, but
+// keeps polling after that once per poll interval until ctx is
+// canceled or the connection is closed. Failed polls, including
+// those without a reply within the poll timeout, are retried
+// with exponential backoff until they terminally repeat once every
+// 20 seconds. After c.failLimit consecutive failures the connection
+// is considered lost and, if enabled, reconnected.
+func (c *Conn) pollStatus(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	c.mu.Lock()
+	c.pollStop, c.pollDone = cancel, done
+	c.mu.Unlock()
+
+	once := make(chan error, 1)
+	go func() {
+		defer close(done)
+		defer cancel()
+		lctx, lcancel := context.WithTimeout(ctx, c.pollTimeout())
+		err := c.modListing(lctx)
+		lcancel()
+		if err != nil {
+			once <- err
+			return
+		}
+		first := true
+		failures := 0
+		var backoff time.Duration
+		for {
+			c.mu.Lock()
+			wait, paused, state := c.pollInterval, c.pollPaused, c.connState
+			c.mu.Unlock()
+			if !paused {
+				var err error
+				pctx, pcancel := context.WithTimeout(ctx, c.pollTimeout())
+				if state == ConnReconnecting {
+					err = c.reconnectOnce(pctx)
+				} else {
+					err = c.StatusContext(pctx)
+				}
+				pcancel()
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("no status: %v (is tool head installed?)", err)
+					}
+					if backoff == 0 {
+						backoff = 250 * time.Millisecond
+					} else if backoff *= 2; backoff > 20*time.Second {
+						backoff = 20 * time.Second
+					}
+					wait = backoff
+					if failures++; failures >= c.failLimit && state == ConnConnected && ctx.Err() == nil {
+						c.mu.Lock()
+						c.setConnState(ConnLost)
+						if c.reconnect {
+							c.setConnState(ConnReconnecting)
+						}
+						c.mu.Unlock()
+					}
+				} else {
+					backoff, failures = 0, 0
+					if state != ConnConnected {
+						c.mu.Lock()
+						c.setConnState(ConnConnected)
+						c.mu.Unlock()
+					}
+					if first {
+						once <- nil
+						first = false
+					}
+				}
+			}
+			select {
+			case <-time.After(wait):
+			case <-c.pollWake:
+			case <-ctx.Done():
+				if first {
+					once <- ErrCanceled
+				}
+				return
+			}
+		}
+	}()
+	return <-once
+}
+
+// wakePoller causes the poller to reconsider its schedule
+// immediately.
+func (c *Conn) wakePoller() {
+	select {
+	case c.pollWake <- struct{}{}:
+	default:
+	}
+}
+
+// stopPolling stops the background poller and waits for it to
+// exit. It is a no-op if no poller is running.
+func (c *Conn) stopPolling() {
+	c.mu.Lock()
+	stop, done := c.pollStop, c.pollDone
+	c.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	stop()
+	<-done
+}
+
+// SetPollInterval changes the period of the background status
+// poller. The new interval takes effect immediately.
+func (c *Conn) SetPollInterval(d time.Duration) error {
+	if d <= 0 {
+		return ErrInvalid
+	}
+	c.mu.Lock()
+	c.pollInterval = d
+	c.mu.Unlock()
+	c.wakePoller()
+	return nil
+}
+
+// PollInterval returns the period of the background status poller.
+func (c *Conn) PollInterval() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pollInterval
+}
+
+// PausePolling suspends the background status poller. While paused,
+// the cached status is only refreshed by explicit Status() calls.
+func (c *Conn) PausePolling() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pollPaused = true
+}
+
+// ResumePolling resumes a paused background status poller, polling
+// immediately.
+func (c *Conn) ResumePolling() {
+	c.mu.Lock()
+	c.pollPaused = false
+	c.mu.Unlock()
+	c.wakePoller()
+}
+
+// PollingPaused indicates the background status poller is paused.
+func (c *Conn) PollingPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pollPaused
+}
+
+// Done returns a channel that is closed when the background status
+// poller has stopped. This happens when the connection is closed, or
+// the context used to create it is canceled.
+func (c *Conn) Done() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pollDone
+}
+
+// LastError returns the error of the most recent status update. It
+// is nil if that update succeeded.
+func (c *Conn) LastError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+// LastUpdated returns the time of the most recent successful status
+// update. Values returned by Homed(), CurrentLocation(), Running()
+// etc are only as fresh as this time.
+func (c *Conn) LastUpdated() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastUpdated
+}
+
+// LastStatus returns the machine status observed by the most recent
+// status update.
+func (c *Conn) LastStatus() StatusResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.toolState
+	st.ModuleList = maps.Clone(st.ModuleList)
+	return st
+}
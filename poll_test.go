@@ -0,0 +1,147 @@
+package snappy_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+	"zappem.net/pub/net/snappy/snappytest"
+)
+
+// awaitUpdate waits for c to record a status update after since.
+func awaitUpdate(t *testing.T, c *snappy.Conn, since time.Time) time.Time {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if last := c.LastUpdated(); last.After(since) {
+			return last
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("no status update after %v", since)
+	return since
+}
+
+func TestPoller(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := snappytest.NewServer(testToken)
+	defer s.Close()
+	c, err := snappy.NewConnWithOptions(ctx, snappy.Options{
+		BaseURL:      s.URL,
+		Token:        testToken,
+		PollInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewConnWithOptions failed: %v", err)
+	}
+	last := awaitUpdate(t, c, c.LastUpdated())
+	if err := c.LastError(); err != nil {
+		t.Errorf("LastError got %v", err)
+	}
+	if st := c.LastStatus(); snappy.ParseMachineState(st.Status) != snappy.StateIdle {
+		t.Errorf("LastStatus got status %q, want %v", st.Status, snappy.StateIdle)
+	}
+
+	c.PausePolling()
+	time.Sleep(30 * time.Millisecond)
+	paused := c.LastUpdated()
+	time.Sleep(50 * time.Millisecond)
+	if now := c.LastUpdated(); !now.Equal(paused) {
+		t.Errorf("paused poller updated: %v -> %v", paused, now)
+	}
+	c.ResumePolling()
+	last = awaitUpdate(t, c, paused)
+
+	if err := c.SetPollInterval(0); err == nil {
+		t.Error("SetPollInterval(0) succeeded")
+	}
+	if err := c.SetPollInterval(time.Hour); err != nil {
+		t.Fatalf("SetPollInterval failed: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("poller still running after Close")
+	}
+	if now := c.LastUpdated(); now.Before(last) {
+		t.Errorf("LastUpdated went backwards: %v -> %v", last, now)
+	}
+}
+
+func TestUnresponsive(t *testing.T) {
+	ctx, s, c := connect(t)
+	if err := c.SetPollInterval(time.Hour); err != nil {
+		t.Fatalf("SetPollInterval failed: %v", err)
+	}
+
+	s.SetUnresponsive(true)
+	pending := make(chan error)
+	go func() {
+		pending <- c.StatusContext(ctx)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// The cached values stay readable while the status is pending.
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		c.LastUpdated()
+		c.LastError()
+		c.ConnState()
+		c.Homed()
+		c.CurrentLocation()
+	}()
+	select {
+	case <-read:
+	case <-time.After(5 * time.Second):
+		t.Fatal("accessors blocked by a pending status request")
+	}
+
+	s.SetUnresponsive(false)
+	if err := <-pending; err != nil {
+		t.Errorf("StatusContext failed: %v", err)
+	}
+}
+
+func TestCloseUnreachable(t *testing.T) {
+	ctx, s, c := connect(t)
+	ch := c.Subscribe(ctx)
+	awaited := make(chan error)
+	go func() {
+		_, err := c.AwaitState(ctx, 0, snappy.StateRunning)
+		awaited <- err
+	}()
+
+	s.SetOffline(true)
+	if err := c.CloseContext(ctx); err == nil {
+		t.Error("CloseContext of an unreachable device succeeded")
+	}
+	if got := c.ConnState(); got != snappy.ConnClosed {
+		t.Errorf("got ConnState()=%v, want %v", got, snappy.ConnClosed)
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("poller still running after CloseContext")
+	}
+	for range ch {
+	}
+	select {
+	case err := <-awaited:
+		if !errors.Is(err, snappy.ErrNotConnected) {
+			t.Errorf("AwaitState got %v, want %v", err, snappy.ErrNotConnected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AwaitState still waiting after CloseContext")
+	}
+	if err := c.StatusContext(ctx); !errors.Is(err, snappy.ErrNotConnected) {
+		t.Errorf("StatusContext after CloseContext got %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package snappy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// MaxNozzleTemperature etc are the largest temperature targets, in
+// degrees C, that will be sent to the machine. MinExtrudeTemperature
+// is the coolest nozzle temperature at which Extrude will move
+// filament.
+const (
+	MaxNozzleTemperature  = 300
+	MaxBedTemperature     = 110
+	MinExtrudeTemperature = 170
+)
+
+// extrudeFeed is the feed rate, in mm/minute, for moving filament.
+const extrudeFeed = 300
+
+// FilamentUnload and FilamentPurge are the lengths of filament, in
+// mm, ChangeFilament retracts to unload the old filament and extrudes
+// to purge it after the new filament is loaded.
+const (
+	FilamentUnload = 100
+	FilamentPurge  = 50
+)
+
+// printTool returns the attached tool head, confirming it is a 3D
+// printing head with the extruder.
+func (c *Conn) printTool(extruder int) (ToolHead, error) {
+	th, err := c.Tool()
+	if err != nil {
+		return ToolHead{}, err
+	}
+	if th.Kind != ToolPrint {
+		return ToolHead{}, fmt.Errorf("tool head %q is not a 3D printing head: %w", th.Name, ErrInvalid)
+	}
+	if extruder < 0 || extruder >= th.Nozzles {
+		return ToolHead{}, fmt.Errorf("tool head %q has no extruder %d: %w", th.Name, extruder, ErrInvalid)
+	}
+	return th, nil
+}
+
+// nozzle returns the temperature and target temperature of the
+// extruder reported by st.
+func nozzle(th ToolHead, st StatusResult, extruder int) (temp, target float64) {
+	switch {
+	case th.Nozzles == 1:
+		return st.NozzleTemperature, st.NozzleTargetTemperature
+	case extruder == 0:
+		return st.NozzleTemperature1, st.NozzleTargetTemperature1
+	default:
+		return st.NozzleTemperature2, st.NozzleTargetTemperature2
+	}
+}
+
+// SetNozzleTemperature sets the target temperature, in degrees C, of
+// the extruder (numbered from 0) of the attached 3D printing head. A
+// target of 0 turns the heater off.
+func (c *Conn) SetNozzleTemperature(ctx context.Context, extruder int, celsius float64) error {
+	if _, err := c.printTool(extruder); err != nil {
+		return err
+	}
+	if celsius < 0 || celsius > MaxNozzleTemperature {
+		return fmt.Errorf("nozzle temperature %g outside 0..%d: %w", celsius, MaxNozzleTemperature, ErrInvalid)
+	}
+	return c.doCode(ctx, fmt.Sprintf("M104 S%g T%d", celsius, extruder))
+}
+
+// SetBedTemperature sets the target temperature, in degrees C, of the
+// heated bed. A target of 0 turns the heater off.
+func (c *Conn) SetBedTemperature(ctx context.Context, celsius float64) error {
+	if celsius < 0 || celsius > MaxBedTemperature {
+		return fmt.Errorf("bed temperature %g outside 0..%d: %w", celsius, MaxBedTemperature, ErrInvalid)
+	}
+	return c.doCode(ctx, fmt.Sprintf("M140 S%g", celsius))
+}
+
+// AwaitTemperature waits until every nozzle and the heated bed with a
+// non-zero target temperature are within tolerance degrees C of
+// their targets. See AwaitFunc for the meaning of timeout and the
+// errors returned.
+func (c *Conn) AwaitTemperature(ctx context.Context, tolerance float64, timeout time.Duration) (StatusResult, error) {
+	th, err := c.printTool(0)
+	if err != nil {
+		return StatusResult{}, err
+	}
+	// Refresh the cached status to observe recently set targets.
+	if err := c.StatusContext(ctx); err != nil {
+		return StatusResult{}, err
+	}
+	near := func(temp, target float64) bool {
+		return target == 0 || math.Abs(temp-target) <= tolerance
+	}
+	return c.AwaitFunc(ctx, timeout, func(st StatusResult) bool {
+		for i := 0; i < th.Nozzles; i++ {
+			if !near(nozzle(th, st, i)) {
+				return false
+			}
+		}
+		return near(st.HeatedBedTemperature, st.HeatedBedTargetTemperature)
+	})
+}
+
+// Extrude feeds mm of filament through the extruder (numbered from
+// 0) of the attached 3D printing head. A negative mm retracts the
+// filament. The nozzle must be at least MinExtrudeTemperature. On a
+// head with more than one nozzle, selecting another extruder is a
+// toolchange, so it is only made for an extruder other than the
+// active one, which is then selected again.
+func (c *Conn) Extrude(ctx context.Context, extruder int, mm float64) error {
+	th, err := c.printTool(extruder)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	temp, _ := nozzle(th, c.toolState, extruder)
+	active := c.extruder
+	c.mu.Unlock()
+	if temp < MinExtrudeTemperature {
+		return fmt.Errorf("nozzle %d at %g C is too cold to extrude: %w", extruder, temp, ErrInvalid)
+	}
+	if err := c.waitToMove(ctx); err != nil {
+		return err
+	}
+	defer c.stopMoving()
+	codes := []string{"M83", fmt.Sprintf("G1 E%.2f F%d", mm, extrudeFeed), "M82"}
+	if extruder == active {
+		return c.doCodes(ctx, codes...)
+	}
+	if err := c.doCode(ctx, fmt.Sprintf("T%d", extruder)); err != nil {
+		return err
+	}
+	c.setExtruder(extruder)
+	err = c.doCodes(ctx, codes...)
+	if rerr := c.doCode(ctx, fmt.Sprintf("T%d", active)); rerr != nil {
+		return errors.Join(err, rerr)
+	}
+	c.setExtruder(active)
+	return err
+}
+
+// setExtruder records the active extruder, as last selected by the
+// connection. Extruder 0 is assumed to be active when connected.
+func (c *Conn) setExtruder(extruder int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.extruder = extruder
+}
+
+// Retract withdraws mm of filament from the extruder. See Extrude.
+func (c *Conn) Retract(ctx context.Context, extruder int, mm float64) error {
+	return c.Extrude(ctx, extruder, -mm)
+}
+
+// filamentHeatTimeout limits the wait for the nozzle to heat up in
+// ChangeFilament.
+const filamentHeatTimeout = 10 * time.Minute
+
+// ChangeFilament guides a change of the filament of the extruder
+// (numbered from 0). The nozzle is heated to celsius, which must be
+// at least MinExtrudeTemperature, the old filament is unloaded, and
+// then prompt is called with instructions for the user. When prompt
+// returns nil the new filament is purged through the nozzle and the
+// original nozzle target is restored. A prompt error abandons the
+// change, leaving the nozzle hot.
+func (c *Conn) ChangeFilament(ctx context.Context, extruder int, celsius float64, prompt func(msg string) error) error {
+	th, err := c.printTool(extruder)
+	if err != nil {
+		return err
+	}
+	if celsius < MinExtrudeTemperature || celsius > MaxNozzleTemperature {
+		return fmt.Errorf("filament change temperature %g outside %d..%d: %w", celsius, MinExtrudeTemperature, MaxNozzleTemperature, ErrInvalid)
+	}
+	c.mu.Lock()
+	_, target := nozzle(th, c.toolState, extruder)
+	c.mu.Unlock()
+	if err := c.SetNozzleTemperature(ctx, extruder, celsius); err != nil {
+		return err
+	}
+	// Only this nozzle need be hot, not the heated bed or any other
+	// nozzle.
+	if err := c.StatusContext(ctx); err != nil {
+		return err
+	}
+	_, err = c.AwaitFunc(ctx, filamentHeatTimeout, func(st StatusResult) bool {
+		temp, _ := nozzle(th, st, extruder)
+		return math.Abs(temp-celsius) <= 5
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.Retract(ctx, extruder, FilamentUnload); err != nil {
+		return err
+	}
+	if err := prompt(fmt.Sprintf("remove the old filament from extruder %d, insert the new filament, then continue", extruder)); err != nil {
+		return err
+	}
+	if err := c.Extrude(ctx, extruder, FilamentPurge); err != nil {
+		return err
+	}
+	return c.SetNozzleTemperature(ctx, extruder, target)
+}
@@ -0,0 +1,112 @@
+package snappy_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+)
+
+func TestPrinting(t *testing.T) {
+	ctx, s, c := connect(t)
+	if err := c.SetNozzleTemperature(ctx, 0, 200); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("laser SetNozzleTemperature got %v want %v", err, snappy.ErrInvalid)
+	}
+
+	s.SetToolHead(18)
+	s.SetHeatRate(50)
+	if err := c.SetPollInterval(10 * time.Millisecond); err != nil {
+		t.Fatalf("SetPollInterval failed: %v", err)
+	}
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	for _, bad := range []struct {
+		extruder int
+		celsius  float64
+	}{{2, 200}, {-1, 200}, {0, 400}, {1, -1}} {
+		if err := c.SetNozzleTemperature(ctx, bad.extruder, bad.celsius); !errors.Is(err, snappy.ErrInvalid) {
+			t.Errorf("SetNozzleTemperature(%d, %g) got %v", bad.extruder, bad.celsius, err)
+		}
+	}
+	if err := c.SetBedTemperature(ctx, 200); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("SetBedTemperature(200) got %v", err)
+	}
+	if err := c.Extrude(ctx, 1, 10); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("cold Extrude got %v", err)
+	}
+
+	if err := c.SetNozzleTemperature(ctx, 1, 210); err != nil {
+		t.Fatalf("SetNozzleTemperature failed: %v", err)
+	}
+	if err := c.SetBedTemperature(ctx, 60); err != nil {
+		t.Fatalf("SetBedTemperature failed: %v", err)
+	}
+	if _, err := c.AwaitTemperature(ctx, 1, 20*time.Millisecond); !errors.Is(err, snappy.ErrTimeout) {
+		t.Errorf("AwaitTemperature got %v want %v", err, snappy.ErrTimeout)
+	}
+	st, err := c.AwaitTemperature(ctx, 1, 5*time.Second)
+	if err != nil {
+		t.Fatalf("AwaitTemperature failed: %v", err)
+	}
+	if st.NozzleTemperature2 != 210 || st.HeatedBedTemperature != 60 || st.NozzleTemperature1 != 0 {
+		t.Errorf("got nozzles %g,%g bed %g", st.NozzleTemperature1, st.NozzleTemperature2, st.HeatedBedTemperature)
+	}
+
+	sent := len(s.Codes())
+	if err := c.Extrude(ctx, 1, 10); err != nil {
+		t.Fatalf("Extrude failed: %v", err)
+	}
+	// The other extruder is selected, then extruder 0 again.
+	want := []string{"T1", "M83", "G1 E10.00 F300", "M82", "T0"}
+	if got := s.Codes()[sent:]; !slices.Equal(got, want) {
+		t.Errorf("Extrude sent %q want %q", got, want)
+	}
+	if err := c.Retract(ctx, 1, 3); err != nil {
+		t.Fatalf("Retract failed: %v", err)
+	}
+	if got := s.Extruded(1); got != 7 {
+		t.Errorf("extruded %g want 7", got)
+	}
+
+	prompts := 0
+	sent = len(s.Codes())
+	if err := c.ChangeFilament(ctx, 0, snappy.MinExtrudeTemperature-10, func(string) error {
+		prompts++
+		return nil
+	}); !errors.Is(err, snappy.ErrInvalid) {
+		t.Errorf("cool ChangeFilament got %v want %v", err, snappy.ErrInvalid)
+	}
+	if codes := s.Codes(); prompts != 0 || len(codes) != sent {
+		t.Errorf("cool ChangeFilament prompted %d times, sent %q", prompts, codes[sent:])
+	}
+	sent = len(s.Codes())
+	if err := c.ChangeFilament(ctx, 0, 220, func(msg string) error {
+		prompts++
+		if got := s.Extruded(0); got != -snappy.FilamentUnload {
+			t.Errorf("prompted with %g extruded", got)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("ChangeFilament failed: %v", err)
+	}
+	if got := s.Extruded(0); prompts != 1 || got != snappy.FilamentPurge-snappy.FilamentUnload {
+		t.Errorf("got %d prompts, extruded %g", prompts, got)
+	}
+	// Extruder 0 remains active, so no toolchange is made.
+	for _, code := range s.Codes()[sent:] {
+		if strings.HasPrefix(code, "T") {
+			t.Errorf("ChangeFilament of the active extruder sent %q", code)
+		}
+	}
+	if st := s.State(); st.NozzleTargetTemperature1 != 0 {
+		t.Errorf("nozzle target not restored: %g", st.NozzleTargetTemperature1)
+	}
+	abandon := errors.New("abandoned")
+	if err := c.ChangeFilament(ctx, 0, 220, func(string) error { return abandon }); err != abandon {
+		t.Errorf("abandoned ChangeFilament got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package snappy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+	"zappem.net/pub/net/snappy/snappytest"
+)
+
+// nextConnState returns the next connection state event.
+func nextConnState(t *testing.T, ch <-chan snappy.StatusEvent) snappy.ConnState {
+	t.Helper()
+	return nextEvent(t, ch, snappy.EventConnection).Connection
+}
+
+func TestReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := snappytest.NewServer(testToken)
+	defer s.Close()
+	c, err := snappy.NewConnWithOptions(ctx, snappy.Options{
+		BaseURL:          s.URL,
+		Token:            testToken,
+		PollInterval:     10 * time.Millisecond,
+		FailureThreshold: 2,
+		Reconnect:        true,
+	})
+	if err != nil {
+		t.Fatalf("NewConnWithOptions failed: %v", err)
+	}
+	defer c.Close()
+	home(t, ctx, c)
+	ch := c.Subscribe(ctx)
+
+	s.SetOffline(true)
+	if got := nextConnState(t, ch); got != snappy.ConnLost {
+		t.Fatalf("got %v want %v", got, snappy.ConnLost)
+	}
+	if got := nextConnState(t, ch); got != snappy.ConnReconnecting {
+		t.Fatalf("got %v want %v", got, snappy.ConnReconnecting)
+	}
+	s.Reboot()
+	s.SetOffline(false)
+	if got := nextConnState(t, ch); got != snappy.ConnConnected {
+		t.Fatalf("got %v want %v", got, snappy.ConnConnected)
+	}
+	if !s.Connected() {
+		t.Fatal("fake not reconnected")
+	}
+	if c.Homed() {
+		t.Error("homed after reboot")
+	}
+	if id, ok, err := c.ToolHead(1); err != nil || !ok || id != 2 {
+		t.Errorf("got ToolHead(1)=%d,%v,%v want 2,true,<nil>", id, ok, err)
+	}
+	home(t, ctx, c)
+}
+
+func TestConnectionLost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := snappytest.NewServer(testToken)
+	defer s.Close()
+	c, err := snappy.NewConnWithOptions(ctx, snappy.Options{
+		BaseURL:          s.URL,
+		Token:            testToken,
+		PollInterval:     10 * time.Millisecond,
+		FailureThreshold: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewConnWithOptions failed: %v", err)
+	}
+	defer c.Close()
+	ch := c.Subscribe(ctx)
+
+	s.SetOffline(true)
+	if got := nextConnState(t, ch); got != snappy.ConnLost {
+		t.Fatalf("got %v want %v", got, snappy.ConnLost)
+	}
+	s.SetOffline(false)
+	if got := nextConnState(t, ch); got != snappy.ConnConnected {
+		t.Fatalf("got %v want %v", got, snappy.ConnConnected)
+	}
+}
+
+func TestConnectionUnresponsive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := snappytest.NewServer(testToken)
+	defer s.Close()
+	c, err := snappy.NewConnWithOptions(ctx, snappy.Options{
+		BaseURL:          s.URL,
+		Token:            testToken,
+		Timeout:          50 * time.Millisecond,
+		PollInterval:     10 * time.Millisecond,
+		FailureThreshold: 2,
+		Reconnect:        true,
+	})
+	if err != nil {
+		t.Fatalf("NewConnWithOptions failed: %v", err)
+	}
+	defer c.Close()
+	ch := c.Subscribe(ctx)
+
+	// Polls that never get a reply time out and count as failures.
+	s.SetUnresponsive(true)
+	if got := nextConnState(t, ch); got != snappy.ConnLost {
+		t.Fatalf("got %v want %v", got, snappy.ConnLost)
+	}
+	if got := nextConnState(t, ch); got != snappy.ConnReconnecting {
+		t.Fatalf("got %v want %v", got, snappy.ConnReconnecting)
+	}
+	if got := c.ConnState(); got != snappy.ConnReconnecting {
+		t.Errorf("got ConnState()=%v want %v", got, snappy.ConnReconnecting)
+	}
+	s.SetUnresponsive(false)
+	if got := nextConnState(t, ch); got != snappy.ConnConnected {
+		t.Fatalf("got %v want %v", got, snappy.ConnConnected)
+	}
+}
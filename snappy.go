@@ -1,19 +1,15 @@
 package snappy
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"mime/multipart"
+	"net"
 	"net/http"
-	"net/textproto"
 	"net/url"
-	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -27,6 +23,8 @@ var (
 	ErrCanceled     = errors.New("operation canceled")
 	ErrInvalid      = errors.New("invalid value")
 	ErrNoCamera     = errors.New("no camera")
+	ErrTimeout      = errors.New("timed out")
+	ErrRejected     = errors.New("connection rejected")
 )
 
 // Module provides basic status information describing the keyed
@@ -39,16 +37,17 @@ type Module struct {
 
 // ModuleNames names the tool heads indexed by their canonical IDs.
 // These names are used in the *nc files for driving these tools.
+// The capabilities of the tool heads are described by ToolHeads.
 var ModuleNames = map[int]string{
-	0:   "??? 1 nozzle 3D printer",     // 3D Print default tool
-	1:   "standardCNCToolheadForSM2",   // CNC default tool 50W
-	2:   "levelOneLaserToolheadForSM2", // Blue Laser 1.6W
-	14:  "levelTwoLaserToolheadForSM2", // Blue Laser 10W
-	15:  "??? 200W CNC",                // CNC high power tool 200W
-	18:  "???Dual",                     // 3D Print dual head tool
-	23:  "2W Laser Module",             // IR Laser 2W
-	519: "Quick Swap Kit",              // quickSwapState?
-	522: "Bracing Kit",                 // bracingKit?
+	0:   "singleExtruderToolheadForSM2", // 3D Print default tool
+	1:   "standardCNCToolheadForSM2",    // CNC default tool 50W
+	2:   "levelOneLaserToolheadForSM2",  // Blue Laser 1.6W
+	14:  "levelTwoLaserToolheadForSM2",  // Blue Laser 10W
+	15:  "levelTwoCNCToolheadForSM2",    // CNC high power tool 200W
+	18:  "dualExtruderToolheadForSM2",   // 3D Print dual head tool
+	23:  "2W Laser Module",              // IR Laser 2W
+	519: "Quick Swap Kit",               // quickSwapState?
+	522: "Bracing Kit",                  // bracingKit?
 }
 
 // ModuleListing is used to indicate the operational status of the
@@ -66,181 +65,6 @@ type EnclosureResult struct {
 	Fan           int  `json:"fan"`
 }
 
-type ModuleType interface {
-	MarshalJSON() ([]byte, error)
-}
-
-type ModuleDetail struct {
-	Key    int `json:"key"`
-	Module ModuleType
-}
-
-type ModuleLaser struct {
-	LaserFocalLength float64 `json:"laserFocalLength"`
-	LaserPower       float64 `json:"laserPower"`
-	LaserCamera      bool    `json:"laserCamera"`
-}
-
-func (ml *ModuleLaser) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf(`"laserFocalLength":%g,"laserPower":%g,"laserCamera":%v`, ml.LaserFocalLength, ml.LaserPower, ml.LaserCamera)), nil
-}
-
-type ModuleEnclosure struct {
-	IsReady             bool `json:"isReady"`
-	LED                 int  `json:"led"`
-	Fan                 int  `json:"fan"`
-	IsDoorEnabled       bool `json:"isDoorEnabled"`
-	IsEnclosureDoorOpen bool `json:"isEnclosureDoorOpen"`
-	DoorSwitchCount     int  `json:"doorSwitchCount"`
-}
-
-func (me *ModuleEnclosure) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf(`"isReady":%v,"led":%d,"fan":%d,"isDoorEnabled":%v,"isEnclosureDoorOpen":%v,"doorSwitchCount":%d`, me.IsReady, me.LED, me.Fan, me.IsDoorEnabled, me.IsEnclosureDoorOpen, me.DoorSwitchCount)), nil
-}
-
-type ModuleEmergencyStop struct {
-	IsEmergencyStopped bool `json:"isEmergencyStopped"`
-}
-
-func (mes *ModuleEmergencyStop) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf(`"isEmergencyStopped":%v`, mes.IsEmergencyStopped)), nil
-}
-
-type ModuleQuickSwap struct {
-	QuickSwapState int `json:"quickSwapState"`
-	QuickSwapType  int `json:"quickSwapType"`
-}
-
-func (mq *ModuleQuickSwap) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf(`"quickSwapState":%d,"quickSwapType":%d`, mq.QuickSwapState, mq.QuickSwapType)), nil
-}
-
-type ModuleBracingKit struct {
-	BracingKitState int `json:"bracingKitState"`
-}
-
-func (mb *ModuleBracingKit) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf(`"bracingKitState":%d`, mb.BracingKitState)), nil
-}
-
-type Module3DBasic struct {
-	NozzleTemperature       float64 `json:"nozzleTemperature"`
-	NozzleTargetTemperature float64 `json:"nozzleTargetTemperature"`
-	IsFilamentOut           bool    `json:"isFilamentOut"`
-}
-
-func (m3 *Module3DBasic) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf(`"nozzleTemperature":%g,"nozzleTargetTemperature":%g,"isFilamentOut":%v`, m3.NozzleTemperature, m3.NozzleTargetTemperature, m3.IsFilamentOut)), nil
-}
-
-type Module3DDual struct {
-	NozzleTemperature1       float64 `json:"nozzleTemperature1"`
-	NozzleTargetTemperature1 float64 `json:"nozzleTargetTemperature1"`
-	NozzleTemperature2       float64 `json:"nozzleTemperature2"`
-	NozzleTargetTemperature2 float64 `json:"nozzleTargetTemperature2"`
-	IsFilamentOut            bool    `json:"isFilamentOut"`
-}
-
-func (m3 *Module3DDual) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf(`"nozzleTemperature1":%g,"nozzleTargetTemperature1":%g,"nozzleTemperature2":%g,"nozzleTargetTemperature2":%g,"isFilamentOut":%v`, m3.NozzleTemperature1, m3.NozzleTargetTemperature1, m3.NozzleTemperature2, m3.NozzleTargetTemperature2, m3.IsFilamentOut)), nil
-}
-
-type ModuleCNC struct {
-	SpindleSpeed int `json:"spindleSpeed"`
-}
-
-func (mc *ModuleCNC) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf(`"spindleSpeed":%d`, mc.SpindleSpeed)), nil
-}
-
-func (m ModuleDetail) String() string {
-	j, err := m.MarshalJSON()
-	if err != nil {
-		return fmt.Sprintf("error:%v", err)
-	}
-	return string(j)
-}
-
-func (m *ModuleDetail) MarshalJSON() ([]byte, error) {
-	b, err := m.Module.MarshalJSON()
-	if err != nil {
-		return nil, err
-	}
-	return []byte(fmt.Sprintf(`{"key":%d,%s}`, m.Key, string(b))), nil
-}
-
-func (m *ModuleDetail) UnmarshalJSON(b []byte) error {
-	if !bytes.HasPrefix(b, []byte(`{"key":`)) {
-		return ErrNoKey
-	}
-	i := bytes.Index(b[7:], []byte(","))
-	if i < 0 {
-		return ErrNoKey
-	}
-	k, err := strconv.Atoi(string(b[7 : 7+i]))
-	if err != nil {
-		return err
-	}
-	if k < 0 {
-		return ErrNoKey
-	}
-	m.Key = k
-	val := append([]byte("{"), b[7+i+1:]...)
-	switch {
-	case bytes.Contains(val, []byte(`"laserPower":`)):
-		ml := &ModuleLaser{}
-		if err := json.Unmarshal(val, ml); err != nil {
-			return err
-		}
-		m.Module = ml
-	case bytes.Contains(val, []byte(`"isEnclosureDoorOpen":`)):
-		me := &ModuleEnclosure{}
-		if err := json.Unmarshal(val, me); err != nil {
-			return err
-		}
-		m.Module = me
-	case bytes.Contains(val, []byte(`"isEmergencyStopped":`)):
-		mes := &ModuleEmergencyStop{}
-		if err := json.Unmarshal(val, mes); err != nil {
-			return err
-		}
-		m.Module = mes
-	case bytes.Contains(val, []byte(`"quickSwapState":`)):
-		mq := &ModuleQuickSwap{}
-		if err := json.Unmarshal(val, mq); err != nil {
-			return err
-		}
-		m.Module = mq
-	case bytes.Contains(val, []byte(`"bracingKitState":`)):
-		mb := &ModuleBracingKit{}
-		if err := json.Unmarshal(val, mb); err != nil {
-			return err
-		}
-		m.Module = mb
-	case bytes.Contains(val, []byte(`"spindleSpeed":`)):
-		mc := &ModuleCNC{}
-		if err := json.Unmarshal(val, mc); err != nil {
-			return err
-		}
-		m.Module = mc
-	case bytes.Contains(val, []byte(`"nozzleTargetTemperature":`)):
-		m3 := &Module3DBasic{}
-		if err := json.Unmarshal(val, m3); err != nil {
-			return err
-		}
-		m.Module = m3
-	case bytes.Contains(val, []byte(`"nozzleTargetTemperature2":`)):
-		m3 := &Module3DDual{}
-		if err := json.Unmarshal(val, m3); err != nil {
-			return err
-		}
-		m.Module = m3
-	default:
-		log.Printf("TODO learn to unmarshal key=%d ... %s", k, val)
-	}
-	return nil
-}
-
 // ModResult holds a response to the module_info query of the A350
 // machine.
 type ModResult struct {
@@ -285,15 +109,39 @@ type StatusResult struct {
 	DoorSwitchCount            int             `json:"doorSwitchCount"`
 }
 
-// Conn holds connection status for Snapmaker 2.0 A350.
+// Conn holds connection status for a Snapmaker 2.0 machine.
 type Conn struct {
 	url          string
 	token        string
+	client       *http.Client
+	timeout      time.Duration
+	userAgent    string
+	statusMu     sync.Mutex
 	mu           sync.Mutex
 	connected    bool
+	pollInterval time.Duration
+	pollPaused   bool
+	pollWake     chan struct{}
+	pollStop     context.CancelFunc
+	pollDone     chan struct{}
+	failLimit    int
+	reconnect    bool
+	connState    ConnState
+	lastErr      error
+	lastUpdated  time.Time
+	closed       chan struct{}
+	subs         map[*subscriber]bool
+	seen         *eventState
+	dropped      uint64
 	moving       bool
 	readOnly     bool
+	model        MachineModel
+	limits       Limits
+	profile      MotionProfile
+	workHeight   float64
+	safeZ        float64
 	headType     int
+	extruder     int
 	hasEnclosure bool
 	modList      ModuleListing
 	encState     EnclosureResult
@@ -321,16 +169,94 @@ type ConnectionResult struct {
 	HasEnclosure bool   `json:"hasEnclosure"`
 }
 
+// cancelBody cancels the context of a request once the response
+// body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and cancels the request context.
+func (cb *cancelBody) Close() error {
+	err := cb.ReadCloser.Close()
+	cb.cancel()
+	return err
+}
+
+// request performs an HTTP request of the device API. The path is
+// relative to the base URL of the device. The request is abandoned
+// if ctx is canceled or the Options.Timeout passes. The caller must
+// close the body of the returned response.
+func (c *Conn) request(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+	}
+	req, err := c.newRequest(ctx, method, path, contentType, body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// newRequest prepares an HTTP request of the device API, with the
+// path relative to the base URL of the device.
+func (c *Conn) newRequest(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	return req, nil
+}
+
+// get performs a GET request of the device API.
+func (c *Conn) get(ctx context.Context, path string) (*http.Response, error) {
+	return c.request(ctx, http.MethodGet, path, "", nil)
+}
+
+// postForm performs a form POST request of the device API.
+func (c *Conn) postForm(ctx context.Context, path string, v url.Values) (*http.Response, error) {
+	return c.request(ctx, http.MethodPost, path, "application/x-www-form-urlencoded", strings.NewReader(v.Encode()))
+}
+
 // Close closes an open connection to the A350.
 func (c *Conn) Close() error {
+	return c.CloseContext(context.Background())
+}
+
+// CloseContext closes an open connection to the A350. The request is
+// abandoned if ctx is canceled. The Conn is closed, its poller
+// stopped and its subscriptions ended, even if the device cannot be
+// told of it, in which case that error is returned.
+func (c *Conn) CloseContext(ctx context.Context) error {
+	c.stopPolling()
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	if !c.connected {
+		c.mu.Unlock()
 		return ErrNotConnected
 	}
+	c.connected = false
+	c.setConnState(ConnClosed)
+	close(c.closed)
+	c.unsubscribeAll()
+	c.mu.Unlock()
+
 	v := url.Values{}
 	v.Set("token", c.token)
-	resp, err := http.PostForm(c.url+"/api/v1/disconnect", v)
+	resp, err := c.postForm(ctx, "/api/v1/disconnect", v)
 	if err != nil {
 		return err
 	}
@@ -338,96 +264,138 @@ func (c *Conn) Close() error {
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("unable to close: status=%d (%q)", resp.StatusCode, resp.Status)
 	}
-	c.connected = false
 	return nil
 }
 
 // encStatus gets the status of the Enclosure.
-func (c *Conn) encStatus() error {
-	resp, err := http.Get(fmt.Sprint(c.url, "/api/v1/enclosure?token=", c.token))
+func (c *Conn) encStatus(ctx context.Context) (EnclosureResult, error) {
+	var enc EnclosureResult
+	resp, err := c.get(ctx, fmt.Sprint("/api/v1/enclosure?token=", c.token))
 	if err != nil {
-		return err
+		return enc, err
 	}
 	defer resp.Body.Close()
 	dec := json.NewDecoder(resp.Body)
-	return dec.Decode(&c.encState)
+	err = dec.Decode(&enc)
+	return enc, err
 }
 
-// modStatus gets the status of the attached modules.
-func (c *Conn) modListing() error {
-	resp, err := http.Get(fmt.Sprint(c.url, "/api/v1/module_list?token=", c.token))
+// modListing gets the list of attached modules.
+func (c *Conn) modListing(ctx context.Context) error {
+	resp, err := c.get(ctx, fmt.Sprint("/api/v1/module_list?token=", c.token))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	var ms ModuleListing
 	dec := json.NewDecoder(resp.Body)
-	return dec.Decode(&c.modList)
+	if err := dec.Decode(&ms); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.modList = ms
+	return nil
 }
 
 // modStatus gets the status of the attached modules.
-func (c *Conn) modStatus() error {
-	resp, err := http.Get(fmt.Sprint(c.url, "/api/v1/module_info?token=", c.token))
+func (c *Conn) modStatus(ctx context.Context) (ModResult, error) {
+	var mod ModResult
+	resp, err := c.get(ctx, fmt.Sprint("/api/v1/module_info?token=", c.token))
 	if err != nil {
-		return err
+		return mod, err
 	}
 	defer resp.Body.Close()
 	dec := json.NewDecoder(resp.Body)
-	return dec.Decode(&c.modState)
+	err = dec.Decode(&mod)
+	return mod, err
 }
 
 // toolStatus gets the status of the tool.
-func (c *Conn) toolStatus() error {
-	resp, err := http.Get(fmt.Sprint(c.url, "/api/v1/status?token=", c.token))
+func (c *Conn) toolStatus(ctx context.Context) (StatusResult, error) {
+	var st StatusResult
+	resp, err := c.get(ctx, fmt.Sprint("/api/v1/status?token=", c.token))
 	if err != nil {
-		return err
+		return st, err
 	}
 	defer resp.Body.Close()
 	dec := json.NewDecoder(resp.Body)
-	return dec.Decode(&c.toolState)
+	err = dec.Decode(&st)
+	return st, err
 }
 
 // Status obtains the status of the machine. Based on connected
 // devices, the status will be obtained for what is found.
 func (c *Conn) Status() error {
+	return c.StatusContext(context.Background())
+}
+
+// StatusContext obtains the status of the machine, abandoning the
+// requests if ctx is canceled. The requests are made without holding
+// c.mu, so the cached values remain readable while they are pending.
+func (c *Conn) StatusContext(ctx context.Context) error {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	if !c.connected {
+	able, hasEnclosure := c.connected, c.hasEnclosure
+	c.mu.Unlock()
+	if !able {
 		return ErrNotConnected
 	}
 
+	var enc EnclosureResult
+	var mod ModResult
+	var st StatusResult
 	var errEnc, errMod, errTool error
 	var wg sync.WaitGroup
-	if c.hasEnclosure {
+	if hasEnclosure {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			errEnc = c.encStatus()
+			enc, errEnc = c.encStatus(ctx)
 		}()
 	}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		errMod = c.modStatus()
+		mod, errMod = c.modStatus(ctx)
 	}()
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		errTool = c.toolStatus()
+		st, errTool = c.toolStatus(ctx)
 	}()
 
 	wg.Wait()
 
-	if errEnc != nil {
-		return fmt.Errorf("enc: %v", errEnc)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return ErrNotConnected
 	}
-	if errMod != nil {
-		return fmt.Errorf("mod: %v", errMod)
+	if hasEnclosure && errEnc == nil {
+		c.encState = enc
 	}
-	if errTool != nil {
-		return fmt.Errorf("tool: %v", errTool)
+	if errMod == nil {
+		c.modState = mod
 	}
-
-	return nil
+	if errTool == nil {
+		c.toolState = st
+	}
+	var err error
+	switch {
+	case errEnc != nil:
+		err = fmt.Errorf("enc: %v", errEnc)
+	case errMod != nil:
+		err = fmt.Errorf("mod: %v", errMod)
+	case errTool != nil:
+		err = fmt.Errorf("tool: %v", errTool)
+	default:
+		c.lastUpdated = time.Now()
+		c.publishChanges()
+	}
+	c.lastErr = err
+	return err
 }
 
 // waitForStatus waits until a successful status has been returned, or
@@ -436,7 +404,7 @@ func (c *Conn) Status() error {
 func (c *Conn) waitForStatus(ctx context.Context) error {
 	duration := time.Duration(250 * time.Millisecond)
 	for {
-		err := c.Status()
+		err := c.StatusContext(ctx)
 		if err == nil {
 			return nil
 		}
@@ -453,40 +421,123 @@ func (c *Conn) waitForStatus(ctx context.Context) error {
 	}
 }
 
-// pollStatus monitors the devices. It returns after one successful
-// status request has been made, but keeps polling after that roughly
-// once per second.
-func (c *Conn) pollStatus(ctx context.Context) (err error) {
-	once := make(chan struct{})
-	go func() {
-		var done bool
-		if err = c.modListing(); err != nil {
-			close(once)
-			return
+// Options holds the parameters for NewConnWithOptions.
+type Options struct {
+	// Address is the IP address of the device. It may include a
+	// ":port" suffix, otherwise port 8080 is used. Address is
+	// ignored if BaseURL is set.
+	Address string
+
+	// BaseURL, if not empty, is the full URL of the device API
+	// server, for example "http://192.168.1.10:8080".
+	BaseURL string
+
+	// Token is the connection token issued by the device.
+	Token string
+
+	// Client is used for all requests. If nil, http.DefaultClient
+	// is used.
+	Client *http.Client
+
+	// Timeout, if non-zero, limits the duration of each request
+	// other than program uploads, which last as long as their
+	// context. Background status polls are limited to 10 seconds
+	// when it is zero.
+	Timeout time.Duration
+
+	// UserAgent, if not empty, is sent with every request.
+	UserAgent string
+
+	// PollInterval is the period of the background status
+	// poller. If zero, the status is polled once per second.
+	PollInterval time.Duration
+
+	// FailureThreshold is the number of consecutive failed status
+	// polls after which the connection is considered lost. If
+	// zero, 3 is used.
+	FailureThreshold int
+
+	// Reconnect enables the automatic re-connection of a lost
+	// connection. The poller repeats the connection handshake,
+	// with the original token, until it succeeds.
+	Reconnect bool
+}
+
+// baseURL returns the base URL of the device API server.
+func (o Options) baseURL() (string, error) {
+	if o.BaseURL != "" {
+		u, err := url.Parse(o.BaseURL)
+		if err != nil {
+			return "", err
 		}
-		for {
-			c.waitForStatus(ctx)
-			if !done {
-				close(once)
-				done = true
-			}
-			select {
-			case <-time.After(1 * time.Second):
-			case <-ctx.Done():
-				return
-			}
+		if u.Scheme == "" || u.Host == "" {
+			return "", fmt.Errorf("invalid base URL %q", o.BaseURL)
 		}
-	}()
-	<-once
-	return
+		return strings.TrimSuffix(o.BaseURL, "/"), nil
+	}
+	if o.Address == "" {
+		return "", fmt.Errorf("no address: %w", ErrInvalid)
+	}
+	if _, _, err := net.SplitHostPort(o.Address); err == nil {
+		return "http://" + o.Address, nil
+	}
+	return fmt.Sprintf("http://%s:8080", o.Address), nil
 }
 
 // NewConn confirms a new connection to the A350.
+// The ip may include a ":port" suffix, otherwise port 8080 is used.
 func NewConn(ctx context.Context, ip, token string) (*Conn, error) {
-	u := fmt.Sprintf("http://%s:8080", ip)
+	return NewConnWithOptions(ctx, Options{
+		Address: ip,
+		Token:   token,
+	})
+}
+
+// newConn prepares an unconnected Conn with the parameters of opts.
+func newConn(opts Options) (*Conn, error) {
+	u, err := opts.baseURL()
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		url:          u,
+		token:        opts.Token,
+		client:       opts.Client,
+		timeout:      opts.Timeout,
+		userAgent:    opts.UserAgent,
+		pollInterval: opts.PollInterval,
+		failLimit:    opts.FailureThreshold,
+		reconnect:    opts.Reconnect,
+		pollWake:     make(chan struct{}, 1),
+		closed:       make(chan struct{}),
+		profile:      DefaultMotionProfile,
+	}
+	if c.client == nil {
+		c.client = http.DefaultClient
+	}
+	if c.pollInterval < 0 {
+		return nil, fmt.Errorf("poll interval %v: %w", c.pollInterval, ErrInvalid)
+	}
+	if c.pollInterval == 0 {
+		c.pollInterval = time.Second
+	}
+	if c.failLimit < 0 {
+		return nil, fmt.Errorf("failure threshold %d: %w", c.failLimit, ErrInvalid)
+	}
+	if c.failLimit == 0 {
+		c.failLimit = 3
+	}
+	return c, nil
+}
+
+// connect performs the connection handshake. An empty c.token
+// requests that the device issue a new one.
+func (c *Conn) connect(ctx context.Context) (*ConnectionResult, error) {
 	v := url.Values{}
-	v.Set("token", token)
-	resp, err := http.PostForm(u+"/api/v1/connect", v)
+	if c.token != "" {
+		v.Set("token", c.token)
+	}
+	resp, err := c.postForm(ctx, "/api/v1/connect", v)
 	if err != nil {
 		return nil, err
 	}
@@ -495,24 +546,41 @@ func NewConn(ctx context.Context, ip, token string) (*Conn, error) {
 		return nil, fmt.Errorf("failed with status=%d (%q)", resp.StatusCode, resp.Status)
 	}
 	j := json.NewDecoder(resp.Body)
-	var res ConnectionResult
-	if err := j.Decode(&res); err != nil {
+	res := &ConnectionResult{}
+	if err := j.Decode(res); err != nil {
 		return nil, err
 	}
-	if token != res.Token {
+	return res, nil
+}
+
+// NewConnWithOptions confirms a new connection to the A350 with
+// the parameters of opts. Status polling continues until ctx is
+// canceled or the connection is closed.
+func NewConnWithOptions(ctx context.Context, opts Options) (*Conn, error) {
+	if opts.Token == "" {
 		return nil, ErrInvalidToken
 	}
-	if res.Series != "Snapmaker 2.0 A350" {
-		return nil, fmt.Errorf("unsupported series %q", res.Series)
+	c, err := newConn(opts)
+	if err != nil {
+		return nil, err
 	}
-	c := &Conn{
-		url:          u,
-		token:        token,
-		connected:    true,
-		readOnly:     res.ReadOnly,
-		headType:     res.HeadType,
-		hasEnclosure: res.HasEnclosure,
+	res, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != res.Token {
+		return nil, ErrInvalidToken
 	}
+	model, err := ModelBySeries(res.Series)
+	if err != nil {
+		return nil, err
+	}
+	c.model = model
+	c.limits = model.Limits()
+	c.connected = true
+	c.readOnly = res.ReadOnly
+	c.headType = res.HeadType
+	c.hasEnclosure = res.HasEnclosure
 	return c, c.pollStatus(ctx)
 }
 
@@ -548,26 +616,19 @@ func (c *Conn) waitToMove(ctx context.Context) error {
 	}
 }
 
-// Await waits for the tool-status to become status.
+// Await waits for the tool-status to become status, one of "IDLE",
+// "RUNNING", "PAUSED" or "STOPPED". See AwaitState for more control.
 func (c *Conn) Await(ctx context.Context, status string) error {
-	for {
-		c.mu.Lock()
-		st := c.toolState.Status
-		c.mu.Unlock()
-		if st == status {
-			break
-		}
-		select {
-		case <-time.After(1 * time.Second):
-		case <-ctx.Done():
-			return ErrCanceled
-		}
+	s := ParseMachineState(status)
+	if s == StateUnknown {
+		return ErrInvalid
 	}
-	return nil
+	_, err := c.AwaitState(ctx, 0, s)
+	return err
 }
 
 // doCode executes some G-Code on the device via a POST method.
-func (c *Conn) doCode(codes string) error {
+func (c *Conn) doCode(ctx context.Context, codes string) error {
 	c.mu.Lock()
 	able := c.connected
 	c.mu.Unlock()
@@ -578,7 +639,7 @@ func (c *Conn) doCode(codes string) error {
 	v := url.Values{}
 	v.Set("token", c.token)
 	v.Set("code", codes)
-	resp, err := http.PostForm(c.url+"/api/v1/execute_code", v)
+	resp, err := c.postForm(ctx, "/api/v1/execute_code", v)
 	if err != nil {
 		return err
 	}
@@ -597,9 +658,9 @@ func (c *Conn) stopMoving() {
 }
 
 // Execute a sequence of codes while the caller holds the moving semaphore.
-func (c *Conn) doCodes(codes ...string) error {
+func (c *Conn) doCodes(ctx context.Context, codes ...string) error {
 	for _, code := range codes {
-		if err := c.doCode(code); err != nil {
+		if err := c.doCode(ctx, code); err != nil {
 			return err
 		}
 	}
@@ -612,7 +673,7 @@ func (c *Conn) Home(ctx context.Context) error {
 		return err
 	}
 	defer c.stopMoving()
-	return c.doCodes("G53", "G28")
+	return c.doCodes(ctx, "G53", "G28")
 }
 
 // SetOrigin sets the work origin of the A350 device. After executing
@@ -624,50 +685,74 @@ func (c *Conn) SetOrigin(ctx context.Context) error {
 		return err
 	}
 	defer c.stopMoving()
-	return c.doCodes("G92 X0 Y0 Z0")
+	return c.doCodes(ctx, "G92 X0 Y0 Z0")
 }
 
-// GoToOrigin moves the tool to the origin of the workspace.
-func (c *Conn) GoToOrigin(ctx context.Context) error {
-	if err := c.waitToMove(ctx); err != nil {
+// GoToOrigin moves the tool to the origin of the workspace,
+// traveling at the SafeZ height. It returns an ErrOutOfBounds error,
+// without moving, if the path leaves the Limits. The opts adjust the
+// MotionProfile of the move, or ask for a direct move.
+func (c *Conn) GoToOrigin(ctx context.Context, opts ...MoveOption) error {
+	return c.MoveTo(ctx, 0, 0, 0, opts...)
+}
+
+// Move to an absolute (x,y,z) location in the current coordinates,
+// traveling at the SafeZ height. It returns an ErrOutOfBounds error,
+// without moving, if the path leaves the Limits. The opts adjust the
+// MotionProfile of the move, or ask for a direct move.
+func (c *Conn) MoveTo(ctx context.Context, x, y, z float64, opts ...MoveOption) error {
+	o, err := c.moveOptions(opts)
+	if err != nil {
 		return err
 	}
-	defer c.stopMoving()
-	c.mu.Lock()
-	z := c.toolState.Z
-	c.mu.Unlock()
-	if z < 0 {
-		return c.doCodes("G0 F1500 Z0", "G0 X0 Y0")
-	} else {
-		return c.doCodes("G0 F1500 X0 Y0", "G0 Z0")
+	path := c.plan([3]float64{x, y, z}, o)
+	if err := c.checkPath(path...); err != nil {
+		return err
 	}
-}
-
-// Move to an absolute (x,y,z) location in the current coordinates.
-func (c *Conn) MoveTo(ctx context.Context, x, y, z float64) error {
 	if err := c.waitToMove(ctx); err != nil {
 		return err
 	}
 	defer c.stopMoving()
-	err := c.doCodes(fmt.Sprintf("G0 F1500 X%.2f Y%.2f Z%.2f", x, y, z))
+	return c.moveAlong(ctx, path, o)
+}
+
+// Step moves a relative step from the current location at the Jog
+// feed. A step down first steps across and then descends at the
+// Plunge feed. It returns an ErrOutOfBounds error, without moving,
+// if the step leaves the Limits. The opts adjust the MotionProfile of
+// the step.
+func (c *Conn) Step(ctx context.Context, dx, dy, dz float64, opts ...MoveOption) error {
+	o, err := c.moveOptions(opts)
 	if err != nil {
 		return err
 	}
 	c.mu.Lock()
-	c.toolState.X = x
-	c.toolState.Y = y
-	c.toolState.Z = z
+	x, y, z := c.toolState.X, c.toolState.Y, c.toolState.Z
 	c.mu.Unlock()
-	return nil
-}
-
-// Step moves a relative step from the current location.
-func (c *Conn) Step(ctx context.Context, dx, dy, dz float64) error {
+	path := [][3]float64{{x + dx, y + dy, z + dz}}
+	steps := [][4]float64{{o.profile.Jog, dx, dy, dz}}
+	if dz < 0 {
+		// Step across first, then down, so that only the descent
+		// is at the Plunge feed.
+		path = [][3]float64{{x + dx, y + dy, z}, {x + dx, y + dy, z + dz}}
+		steps = [][4]float64{{o.profile.Jog, dx, dy, 0}, {o.profile.Plunge, 0, 0, dz}}
+		if dx == 0 && dy == 0 {
+			path, steps = path[1:], steps[1:]
+		}
+	}
+	if err := c.checkPath(path...); err != nil {
+		return err
+	}
+	codes := []string{"G91"}
+	for _, st := range steps {
+		codes = append(codes, fmt.Sprintf("G0 F%g X%.2f Y%.2f Z%.2f", st[0], st[1], st[2], st[3]))
+	}
+	codes = append(codes, "G90")
 	if err := c.waitToMove(ctx); err != nil {
 		return err
 	}
 	defer c.stopMoving()
-	err := c.doCodes("G91", fmt.Sprintf("G0 F1500 X%.2f Y%.2f Z%.2f", dx, dy, dz), "G90")
+	err = c.doCodes(ctx, codes...)
 	if err != nil {
 		return err
 	}
@@ -675,16 +760,25 @@ func (c *Conn) Step(ctx context.Context, dx, dy, dz float64) error {
 	return err
 }
 
-// LaserSpot sets the current laser power to percent.
+// LaserSpot sets the current laser power to percent. The power is
+// limited to MaxSpotPower, and a laser with a SafetyLock is only
+// turned on if it reports no errors, such as ErrLaserLocked.
 func (c *Conn) LaserSpot(ctx context.Context, power float64) error {
-	if power < 0 || power > 1.5 {
+	if power < 0 || power > c.MaxSpotPower() {
 		return ErrInvalid
 	}
+	if power > 0 {
+		if err := c.laserReady(ctx); err != nil {
+			return err
+		}
+	}
 	if err := c.waitToMove(ctx); err != nil {
 		return err
 	}
 	defer c.stopMoving()
-	return c.doCodes(fmt.Sprintf("M3 P%d S%.2f", int(power), 255*(power/100)))
+	// P is not truncated to a whole percent, since the limit of a
+	// powerful laser is a fraction of one.
+	return c.doCodes(ctx, fmt.Sprintf("M3 P%.4g S%.2f", power, 255*(power/100)))
 }
 
 // LaserCrossHairs sets the cross-hair targeting sight on.
@@ -697,21 +791,41 @@ func (c *Conn) LaserCrossHairs(ctx context.Context, enable bool) error {
 	if !enable {
 		on = 0
 	}
-	return c.doCodes(fmt.Sprintf("M2002 T3 P%d", on))
+	return c.doCodes(ctx, fmt.Sprintf("M2002 T3 P%d", on))
 }
 
-// SnapAtJPEG takes a photo (index=0...8) at absolute location (x,y,z).
+// SnapAtJPEG takes a photo (index=0...8) at absolute location
+// (x,y,z), traveling there at the SafeZ height with the Travel feed
+// of the MotionProfile. It returns an ErrOutOfBounds error, without
+// moving, if the path leaves the Limits.
 func (c *Conn) SnapAtJPEG(ctx context.Context, index int, x, y, z float64) ([]byte, error) {
 	c.mu.Lock()
 	hasCamera := c.toolState.LaserCamera
 	c.mu.Unlock()
+	if th, err := c.Tool(); err == nil {
+		hasCamera = th.Camera
+	}
 	if !hasCamera {
 		return nil, ErrNoCamera
 	}
+	o, err := c.moveOptions(nil)
+	if err != nil {
+		return nil, err
+	}
+	path := c.plan([3]float64{x, y, z}, o)
+	if err := c.checkPath(path...); err != nil {
+		return nil, err
+	}
 	if err := c.waitToMove(ctx); err != nil {
 		return nil, err
 	}
-	resp, err := http.Get(fmt.Sprintf("%s/api/request_capture_photo?index=%d&x=%.3f&y=%.3f&z=%.3f&feedRate=3000&photoQuality=31", c.url, index, x, y, z))
+	// The capture request makes the final move.
+	if err := c.moveAlong(ctx, path[:len(path)-1], o); err != nil {
+		c.stopMoving()
+		return nil, err
+	}
+	feed := c.legFeed(path[len(path)-1], o)
+	resp, err := c.get(ctx, fmt.Sprintf("/api/request_capture_photo?index=%d&x=%.3f&y=%.3f&z=%.3f&feedRate=%g&photoQuality=31", index, x, y, z, feed))
 	if err != nil {
 		c.stopMoving()
 		return nil, err
@@ -721,11 +835,14 @@ func (c *Conn) SnapAtJPEG(ctx context.Context, index int, x, y, z float64) ([]by
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("capture[%d] = %q(%d)", index, resp.Status, resp.StatusCode)
 	}
-	resp, err = http.Get(fmt.Sprintf("%s/api/get_camera_image?index=%d", c.url, index))
-	defer resp.Body.Close()
+	c.mu.Lock()
+	c.toolState.X, c.toolState.Y, c.toolState.Z = x, y, z
+	c.mu.Unlock()
+	resp, err = c.get(ctx, fmt.Sprintf("/api/get_camera_image?index=%d", index))
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("image[%d] = %q(%d)", index, resp.Status, resp.StatusCode)
 	}
@@ -743,13 +860,19 @@ func (c *Conn) SnapJPEG(ctx context.Context, index int) ([]byte, error) {
 // EncFan sets the speed of the enclosure fan to a percent of its
 // rated speed.
 func (c *Conn) EncFan(speed int) error {
+	return c.EncFanContext(context.Background(), speed)
+}
+
+// EncFanContext sets the speed of the enclosure fan to a percent of
+// its rated speed, abandoning the request if ctx is canceled.
+func (c *Conn) EncFanContext(ctx context.Context, speed int) error {
 	if speed < 0 || speed > 100 {
 		return ErrInvalid
 	}
 	v := url.Values{}
 	v.Set("token", c.token)
 	v.Set("fan", fmt.Sprint(speed))
-	resp, err := http.PostForm(c.url+"/api/v1/enclosure", v)
+	resp, err := c.postForm(ctx, "/api/v1/enclosure", v)
 	if err != nil {
 		return err
 	}
@@ -763,13 +886,19 @@ func (c *Conn) EncFan(speed int) error {
 // EncLED sets the speed of the enclosure LED to a percent of its
 // rated speed.
 func (c *Conn) EncLED(led int) error {
+	return c.EncLEDContext(context.Background(), led)
+}
+
+// EncLEDContext sets the brightness of the enclosure LED to a
+// percent of its maximum, abandoning the request if ctx is canceled.
+func (c *Conn) EncLEDContext(ctx context.Context, led int) error {
 	if led < 0 || led > 100 {
 		return ErrInvalid
 	}
 	v := url.Values{}
 	v.Set("token", c.token)
 	v.Set("led", fmt.Sprint(led))
-	resp, err := http.PostForm(c.url+"/api/v1/enclosure", v)
+	resp, err := c.postForm(ctx, "/api/v1/enclosure", v)
 	if err != nil {
 		return err
 	}
@@ -818,94 +947,63 @@ func (c *Conn) Running() (ok bool, result string) {
 	}
 	end := time.Now().Add(time.Duration(remaining) * time.Second)
 	result = fmt.Sprintf("%s %q %s %d/%d (%d%%) %.0v ETA %s", status, file, printing, curLines, totLines, (100*curLines)/totLines, time.Microsecond*time.Duration(1e6*elapsed), end.Format(time.DateTime))
-	ok = printing == "Printing"
+	ok = ParseJobState(printing) == JobPrinting
 	return
 }
 
 // RunProgram uploads a program and runs it. It may be subsequently
-// PauseProgram()d and/or StopProgram()d.
+// PauseProgram()d and/or StopProgram()d. Programs for a tool head
+// that NeedsHeader, such as the 2W IR laser, have one generated by
+// NewHeader added if they have none.
 func (c *Conn) RunProgram(name string, data []byte) error {
-	buf := &bytes.Buffer{}
-	wr := multipart.NewWriter(buf)
-
-	part, err := wr.CreateFormField("token")
-	if err != nil {
-		return err
-	}
-	part.Write([]byte(c.token))
-
-	ct := "application/octet-stream"
-	content := "Laser"
-	c.mu.Lock()
-	if strings.Contains(c.toolState.ToolHead, "_CNC_") {
-		content = "CNC"
-	}
-	c.mu.Unlock()
-
-	part, err = wr.CreateFormField("type")
-	if err != nil {
-		return err
-	}
-	part.Write([]byte(content))
-
-	mh := make(textproto.MIMEHeader)
-	mh.Set("Content-Disposition", fmt.Sprintf("form-data; name=\"file\"; filename=%q", filepath.Base(name)))
-	mh.Set("Content-Type", ct)
-	part, err = wr.CreatePart(mh)
-	if err != nil {
-		return err
-	}
-	part.Write(data)
-	if err = wr.Close(); err != nil {
-		return err
-	}
-
-	resp, err := http.Post(c.url+"/api/v1/prepare_print", "multipart/form-data; boundary="+wr.Boundary(), buf)
-	if err != nil {
-		return err
-	}
-	result, err := io.ReadAll(resp.Body)
-	resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("prep failed with %s: %v", string(result), err)
-		return fmt.Errorf("unable to prepare %q: %s", name, resp.Status)
-	}
+	return c.RunProgramContext(context.Background(), name, data)
+}
 
-	v := url.Values{}
-	v.Set("token", c.token)
-	resp, err = http.PostForm(c.url+"/api/v1/start_print", v)
-	if err != nil {
+// RunProgramContext uploads a program and runs it, abandoning the
+// upload if ctx is canceled. See UploadProgram and
+// StartPreparedProgram to do this in two steps.
+func (c *Conn) RunProgramContext(ctx context.Context, name string, data []byte, opts ...UploadOption) error {
+	if err := c.UploadProgram(ctx, name, data, opts...); err != nil {
 		return err
 	}
-	result, err = io.ReadAll(resp.Body)
-	resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("run failed with %s: %v", string(result), err)
-		return fmt.Errorf("unable to run program %q: %s", name, resp.Status)
+	if err := c.StartPreparedProgram(ctx); err != nil {
+		return fmt.Errorf("prepared %q, but %w", name, err)
 	}
 	return nil
 }
 
-// PauseProgram pauses (see RestartProgram) the current running program.
+// PauseProgram pauses (see ResumeProgram) the current running program.
 func (c *Conn) PauseProgram() error {
+	return c.PauseProgramContext(context.Background())
+}
+
+// PauseProgramContext pauses the current running program, abandoning
+// the request if ctx is canceled.
+func (c *Conn) PauseProgramContext(ctx context.Context) error {
 	v := url.Values{}
 	v.Set("token", c.token)
-	resp, err := http.PostForm(c.url+"/api/v1/pause_print", v)
+	resp, err := c.postForm(ctx, "/api/v1/pause_print", v)
 	if err != nil {
 		return err
 	}
 	resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unable to pause program: %v", err)
+		return fmt.Errorf("unable to pause program: %s", resp.Status)
 	}
 	return nil
 }
 
 // ResumeProgram resumes a program from the point of it being Paused.
 func (c *Conn) ResumeProgram() error {
+	return c.ResumeProgramContext(context.Background())
+}
+
+// ResumeProgramContext resumes a paused program, abandoning the
+// request if ctx is canceled.
+func (c *Conn) ResumeProgramContext(ctx context.Context) error {
 	v := url.Values{}
 	v.Set("token", c.token)
-	resp, err := http.PostForm(c.url+"/api/v1/resume_print", v)
+	resp, err := c.postForm(ctx, "/api/v1/resume_print", v)
 	if err != nil {
 		return err
 	}
@@ -919,9 +1017,15 @@ func (c *Conn) ResumeProgram() error {
 // StopProgram terminates the current program job. Care is needed to
 // continue to use the A350 given its ambiguous resulting state.
 func (c *Conn) StopProgram() error {
+	return c.StopProgramContext(context.Background())
+}
+
+// StopProgramContext terminates the current program job, abandoning
+// the request if ctx is canceled.
+func (c *Conn) StopProgramContext(ctx context.Context) error {
 	v := url.Values{}
 	v.Set("token", c.token)
-	resp, err := http.PostForm(c.url+"/api/v1/stop_print", v)
+	resp, err := c.postForm(ctx, "/api/v1/stop_print", v)
 	if err != nil {
 		return err
 	}
@@ -944,15 +1048,35 @@ func (c *Conn) EnclosureFanNotRunning() bool {
 	return c.encState.Fan == 0
 }
 
+// Enclosure returns the enclosure state observed by the most recent
+// status. The ok value is false if the machine has no enclosure.
+func (c *Conn) Enclosure() (enc EnclosureResult, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.encState, c.hasEnclosure
+}
+
+// ModuleInfo returns the details of the attached modules observed
+// by the most recent status.
+func (c *Conn) ModuleInfo() ModResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ModResult{ModuleInfo: append([]ModuleDetail(nil), c.modState.ModuleInfo...)}
+}
+
 // ModuleList returns the lists of modules observed at connection
 // time.
 func (c *Conn) ModuleList() ModuleListing {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.modList
 }
 
 // ToolHead returns the key tool ID and its status value.
 // The main tool head is key=1.
 func (c *Conn) ToolHead(key int) (id int, ok bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	for _, m := range c.modList.ModuleList {
 		if m.Key == key {
 			return m.ModuleID, m.Status, nil
@@ -0,0 +1,253 @@
+package snappy_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+	"zappem.net/pub/net/snappy/snappytest"
+)
+
+const testToken = "0123-4567"
+
+// connect starts a fake A350 and connects to it.
+func connect(t *testing.T) (context.Context, *snappytest.Server, *snappy.Conn) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := snappytest.NewServer(testToken)
+	t.Cleanup(s.Close)
+	c, err := snappy.NewConn(ctx, s.Addr(), testToken)
+	if err != nil {
+		t.Fatalf("NewConn failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return ctx, s, c
+}
+
+// home homes the connected device.
+func home(t *testing.T, ctx context.Context, c *snappy.Conn) {
+	t.Helper()
+	if err := c.Home(ctx); err != nil {
+		t.Fatalf("Home failed: %v", err)
+	}
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if !c.Homed() {
+		t.Fatal("not homed after Home")
+	}
+}
+
+func TestConnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := snappytest.NewServer(testToken)
+	defer s.Close()
+	if _, err := snappy.NewConn(ctx, s.Addr(), "wrong"); err == nil {
+		t.Fatal("connected with the wrong token")
+	}
+	c, err := snappy.NewConn(ctx, s.Addr(), testToken)
+	if err != nil {
+		t.Fatalf("NewConn failed: %v", err)
+	}
+	if id, ok, err := c.ToolHead(1); err != nil || !ok || id != 2 {
+		t.Errorf("got ToolHead(1)=%d,%v,%v want 2,true,<nil>", id, ok, err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if s.Connected() {
+		t.Error("fake still connected after Close")
+	}
+	if err := c.Close(); err != snappy.ErrNotConnected {
+		t.Errorf("second Close got %v, want %v", err, snappy.ErrNotConnected)
+	}
+}
+
+// agentRecorder records the User-Agent headers of requests.
+type agentRecorder struct {
+	mu     sync.Mutex
+	agents map[string]int
+}
+
+func (ar *agentRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	ar.mu.Lock()
+	ar.agents[req.Header.Get("User-Agent")]++
+	ar.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := snappytest.NewServer(testToken)
+	defer s.Close()
+	if _, err := snappy.NewConnWithOptions(ctx, snappy.Options{Token: testToken}); err == nil {
+		t.Fatal("connected without an address")
+	}
+	ar := &agentRecorder{agents: make(map[string]int)}
+	c, err := snappy.NewConnWithOptions(ctx, snappy.Options{
+		BaseURL:   s.URL + "/",
+		Token:     testToken,
+		Client:    &http.Client{Transport: ar},
+		Timeout:   5 * time.Second,
+		UserAgent: "snappy-test/1.0",
+	})
+	if err != nil {
+		t.Fatalf("NewConnWithOptions failed: %v", err)
+	}
+	if err := c.EncLED(50); err != nil {
+		t.Fatalf("EncLED failed: %v", err)
+	}
+	if enc := s.Enclosure(); enc.LED != 50 {
+		t.Errorf("got LED=%d want 50", enc.LED)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if len(ar.agents) != 1 || ar.agents["snappy-test/1.0"] == 0 {
+		t.Errorf("unexpected user agents: %v", ar.agents)
+	}
+}
+
+func TestEnclosure(t *testing.T) {
+	ctx, s, c := connect(t)
+	if err := c.EncFanContext(ctx, 80); err != nil {
+		t.Fatalf("EncFanContext failed: %v", err)
+	}
+	if err := c.StatusContext(ctx); err != nil {
+		t.Fatalf("StatusContext failed: %v", err)
+	}
+	if enc, ok := c.Enclosure(); !ok || enc.Fan != 80 {
+		t.Errorf("got Enclosure()=%#v,%v want fan=80,true", enc, ok)
+	}
+
+	s.SetEnclosure(false)
+	c2, err := snappy.NewConn(ctx, s.Addr(), testToken)
+	if err != nil {
+		t.Fatalf("NewConn failed: %v", err)
+	}
+	defer c2.Close()
+	if _, ok := c2.Enclosure(); ok {
+		t.Error("Enclosure reported for a machine without one")
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	_, _, c := connect(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.EncFanContext(ctx, 100); err == nil {
+		t.Error("EncFanContext succeeded with canceled context")
+	}
+	if err := c.StatusContext(ctx); err == nil {
+		t.Error("StatusContext succeeded with canceled context")
+	}
+	if err := c.Home(ctx); err == nil {
+		t.Error("Home succeeded with canceled context")
+	}
+}
+
+func TestMotion(t *testing.T) {
+	ctx, s, c := connect(t)
+	if c.Homed() {
+		t.Fatal("homed before Home")
+	}
+	home(t, ctx, c)
+
+	if err := c.MoveTo(ctx, 10, 20, 30); err != nil {
+		t.Fatalf("MoveTo failed: %v", err)
+	}
+	if st := s.State(); st.X != 10 || st.Y != 20 || st.Z != 30 {
+		t.Errorf("MoveTo got (%g,%g,%g) want (10,20,30)", st.X, st.Y, st.Z)
+	}
+
+	if err := c.Step(ctx, 1, -1, 0.5); err != nil {
+		t.Fatalf("Step failed: %v", err)
+	}
+	x, y, z, _, _, _ := c.CurrentLocation()
+	if x != 11 || y != 19 || z != 30.5 {
+		t.Errorf("Step got (%g,%g,%g) want (11,19,30.5)", x, y, z)
+	}
+
+	if err := c.SetOrigin(ctx); err != nil {
+		t.Fatalf("SetOrigin failed: %v", err)
+	}
+	if err := c.MoveTo(ctx, 5, 5, 5); err != nil {
+		t.Fatalf("MoveTo failed: %v", err)
+	}
+	if err := c.GoToOrigin(ctx); err != nil {
+		t.Fatalf("GoToOrigin failed: %v", err)
+	}
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	x, y, z, ox, oy, oz := c.CurrentLocation()
+	if x != 0 || y != 0 || z != 0 || ox != -11 || oy != -19 || oz != -30.5 {
+		t.Errorf("got (%g,%g,%g) offset=(%g,%g,%g) want (0,0,0) offset=(-11,-19,-30.5)", x, y, z, ox, oy, oz)
+	}
+}
+
+func TestRunProgram(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+
+	data := []byte(";Header Start\nG0 X1 Y1\nG0 X2 Y2\nG0 X3 Y3\n")
+	if err := c.RunProgram("/tmp/job.nc", data); err != nil {
+		t.Fatalf("RunProgram failed: %v", err)
+	}
+	p, ok := s.Program()
+	if !ok {
+		t.Fatal("no program uploaded")
+	}
+	if p.Name != "job.nc" || p.Type != "Laser" || !bytes.Equal(p.Data, data) {
+		t.Errorf("got program %q (%s) %q", p.Name, p.Type, p.Data)
+	}
+
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if ok, status := c.Running(); !ok {
+		t.Fatalf("program not running: %s", status)
+	}
+	if err := c.PauseProgram(); err != nil {
+		t.Fatalf("PauseProgram failed: %v", err)
+	}
+	if err := c.ResumeProgram(); err != nil {
+		t.Fatalf("ResumeProgram failed: %v", err)
+	}
+
+	s.Advance(4)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if err := c.Await(ctx, "IDLE"); err != nil {
+		t.Fatalf("Await failed: %v", err)
+	}
+	if ok, status := c.Running(); ok {
+		t.Errorf("program still running: %s", status)
+	}
+}
+
+func TestSnapJPEG(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+
+	d, err := c.SnapAtJPEG(ctx, 3, 1, 2, 3)
+	if err != nil {
+		t.Fatalf("SnapAtJPEG failed: %v", err)
+	}
+	if !bytes.HasPrefix(d, []byte{0xff, 0xd8}) {
+		t.Errorf("not a JPEG image: % x", d[:4])
+	}
+	if st := s.State(); st.X != 1 || st.Y != 2 || st.Z != 3 {
+		t.Errorf("camera at (%g,%g,%g) want (1,2,3)", st.X, st.Y, st.Z)
+	}
+}
@@ -0,0 +1,61 @@
+package snappytest
+
+import (
+	"fmt"
+	"net"
+)
+
+// Responder is a fake responder to the UDP discovery requests of
+// snappy.Discover.
+type Responder struct {
+	conn  net.PacketConn
+	reply string
+	done  chan struct{}
+}
+
+// NewResponder starts a discovery responder on a local UDP port. It
+// replies to "discover" requests in the manner of a Snapmaker named
+// name of the given model. Callers should Close() the responder when
+// done.
+func NewResponder(name, model string) (*Responder, error) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	r := &Responder{
+		conn:  conn,
+		reply: fmt.Sprintf("%s@127.0.0.1|model:%s|status:IDLE", name, model),
+		done:  make(chan struct{}),
+	}
+	go r.serve()
+	return r, nil
+}
+
+// serve replies to discovery requests until the responder is closed.
+func (r *Responder) serve() {
+	defer close(r.done)
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if string(buf[:n]) != "discover" {
+			continue
+		}
+		r.conn.WriteTo([]byte(r.reply), from)
+	}
+}
+
+// Addr returns the UDP address of the responder. It can be used as
+// the addr argument of snappy.DiscoverAt.
+func (r *Responder) Addr() string {
+	return r.conn.LocalAddr().String()
+}
+
+// Close stops the responder.
+func (r *Responder) Close() error {
+	err := r.conn.Close()
+	<-r.done
+	return err
+}
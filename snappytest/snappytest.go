@@ -0,0 +1,939 @@
+// Package snappytest provides an in-process fake of the Snapmaker 2.0
+// A350 HTTP API. It is intended for testing code that uses the
+// snappy package without a real machine on the LAN.
+package snappytest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"zappem.net/pub/net/snappy"
+)
+
+// Program holds the most recent program uploaded to the fake via
+// the prepare_print API.
+type Program struct {
+	Name string
+	Type string
+	Data []byte
+
+	// Length is the Content-Length of the upload, or -1 if it was
+	// sent chunked.
+	Length int64
+}
+
+// Server is a fake A350 controller. It holds a simulated machine
+// state that reacts to the G-code sent to it. Machine coordinates
+// are related to the reported work coordinates by
+// machine = work - offset.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	token      string
+	pending    string
+	rejected   string
+	series     string
+	offline    bool
+	hung       chan struct{}
+	connected  bool
+	relative   bool
+	feed       float64
+	airAssist  bool
+	spindleRPM int
+	spindleCW  bool
+	heatRate   float64
+	relativeE  bool
+	extruder   int
+	extruded   [2]float64
+	toolID     int
+	enclosure  bool
+	estop      *bool
+	advance    int
+	state      snappy.StatusResult
+	enc        snappy.EnclosureResult
+	codes      []string
+	program    *Program
+	photos     map[int][]byte
+}
+
+// NewServer starts a fake A350 that accepts token. It simulates a
+// machine with a 1.6W laser tool head and an enclosure. Callers
+// should Close() the server when done.
+func NewServer(token string) *Server {
+	s := &Server{
+		token:     token,
+		series:    "Snapmaker 2.0 A350",
+		enclosure: true,
+		photos:    make(map[int][]byte),
+		state: snappy.StatusResult{
+			Status:      "IDLE",
+			PrintStatus: "Idle",
+			ModuleList:  map[string]bool{"enclosure": true},
+		},
+		enc: snappy.EnclosureResult{
+			IsReady:       true,
+			IsDoorEnabled: true,
+		},
+	}
+	s.setToolHead(2)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/connect", s.handleConnect)
+	mux.HandleFunc("/api/v1/disconnect", s.handleDisconnect)
+	mux.HandleFunc("/api/v1/status", s.handleStatus)
+	mux.HandleFunc("/api/v1/module_list", s.handleModuleList)
+	mux.HandleFunc("/api/v1/module_info", s.handleModuleInfo)
+	mux.HandleFunc("/api/v1/enclosure", s.handleEnclosure)
+	mux.HandleFunc("/api/v1/execute_code", s.handleExecuteCode)
+	mux.HandleFunc("/api/v1/prepare_print", s.handlePreparePrint)
+	mux.HandleFunc("/api/v1/start_print", s.handleStartPrint)
+	mux.HandleFunc("/api/v1/pause_print", s.jobControl("RUNNING", "PAUSED", "Paused"))
+	mux.HandleFunc("/api/v1/resume_print", s.jobControl("PAUSED", "RUNNING", "Printing"))
+	mux.HandleFunc("/api/v1/stop_print", s.jobControl("", "STOPPED", "Stopped"))
+	mux.HandleFunc("/api/request_capture_photo", s.handleCapture)
+	mux.HandleFunc("/api/get_camera_image", s.handleImage)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		offline, hung := s.offline, s.hung
+		s.mu.Unlock()
+		if offline {
+			http.Error(w, "offline", http.StatusServiceUnavailable)
+			return
+		}
+		if hung != nil {
+			select {
+			case <-hung:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// SetOffline simulates the fake being unreachable, for example a
+// dropped Wi-Fi connection. All requests fail while offline.
+func (s *Server) SetOffline(offline bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offline = offline
+}
+
+// SetUnresponsive simulates a machine that stops answering, for
+// example one that has crashed. While unresponsive, requests are
+// held without a reply until they are abandoned by the client or
+// the fake becomes responsive again.
+func (s *Server) SetUnresponsive(unresponsive bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case unresponsive && s.hung == nil:
+		s.hung = make(chan struct{})
+	case !unresponsive && s.hung != nil:
+		close(s.hung)
+		s.hung = nil
+	}
+}
+
+// Close releases any held requests and shuts down the fake.
+func (s *Server) Close() {
+	s.SetUnresponsive(false)
+	s.Server.Close()
+}
+
+// Reboot simulates the machine restarting. The client session is
+// dropped, so subsequent requests fail until the client reconnects,
+// and the machine is no longer homed.
+func (s *Server) Reboot() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = false
+	s.relative = false
+	st := &s.state
+	st.Homed = false
+	st.Status, st.PrintStatus = "IDLE", "Idle"
+	st.X, st.Y, st.Z = 0, 0, 0
+	st.OffsetX, st.OffsetY, st.OffsetZ = 0, 0, 0
+	st.TotalLines, st.CurrentLine, st.Progress = 0, 0, 0
+}
+
+// Addr returns the host:port address of the fake. This value can
+// be used as the ip argument of snappy.NewConn.
+func (s *Server) Addr() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// setToolHead records the simulated tool head, one of
+// snappy.ToolHeads. The caller must hold s.mu or have exclusive
+// access to s.
+func (s *Server) setToolHead(id int) {
+	th, _ := snappy.ToolHeadByID(id)
+	s.toolID = id
+	s.state.ToolHead = th.Report
+	s.state.LaserCamera = th.Camera
+}
+
+// SetToolHead replaces the simulated tool head with the one
+// identified by the snappy.ModuleNames id.
+func (s *Server) SetToolHead(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setToolHead(id)
+}
+
+// SetSeries replaces the series string reported by the fake, for
+// example "Snapmaker 2.0 A250".
+func (s *Server) SetSeries(series string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.series = series
+}
+
+// SetEnclosure indicates whether the fake has an enclosure.
+func (s *Server) SetEnclosure(present bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enclosure = present
+	s.state.ModuleList["enclosure"] = present
+}
+
+// SetEmergencyStop attaches a simulated emergency stop button to the
+// fake and sets whether it is pressed.
+func (s *Server) SetEmergencyStop(stopped bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.estop = &stopped
+	s.state.ModuleList["emergencyStopButton"] = true
+}
+
+// SetAutoAdvance causes each status request to advance a running
+// job by lines program lines. The default, 0, means jobs only
+// progress via Advance().
+func (s *Server) SetAutoAdvance(lines int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance = lines
+}
+
+// Update invokes fn with the simulated status for arbitrary
+// modification, for example to open the enclosure door.
+func (s *Server) Update(fn func(st *snappy.StatusResult)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.state)
+}
+
+// State returns a copy of the simulated status.
+func (s *Server) State() snappy.StatusResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Enclosure returns a copy of the simulated enclosure state.
+func (s *Server) Enclosure() snappy.EnclosureResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc
+}
+
+// Codes returns all of the G-code lines executed so far.
+func (s *Server) Codes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.codes...)
+}
+
+// Feed returns the most recently commanded feed rate (mm/min).
+func (s *Server) Feed() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.feed
+}
+
+// Spindle returns the speed and direction of the simulated CNC
+// spindle. A stopped spindle has rpm=0.
+func (s *Server) Spindle() (rpm int, cw bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spindleRPM, s.spindleCW
+}
+
+// SetHeatRate sets the most the simulated temperatures change, in
+// degrees C, towards their targets with each status request. The
+// default rate of zero reaches the targets immediately.
+func (s *Server) SetHeatRate(rate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.heatRate = rate
+}
+
+// Extruded returns the net length of filament, in mm, fed through
+// the extruder (numbered from 0) of the simulated 3D printing head.
+func (s *Server) Extruded(extruder int) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.extruded[extruder]
+}
+
+// AirAssist indicates the simulated air assist pump is on.
+func (s *Server) AirAssist() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.airAssist
+}
+
+// SetLaserError sets the Laser10WErrorState bits reported by the
+// fake. While any bit is set, the fake refuses to turn on the laser.
+// No code clears the locked bit (1<<3): SetLaserError(0) models
+// unlocking the laser on the touchscreen.
+func (s *Server) SetLaserError(state int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Laser10WErrorState = state
+}
+
+// Connected indicates a client currently holds a connection.
+func (s *Server) Connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected
+}
+
+// Program returns the most recently prepared program, if any.
+func (s *Server) Program() (Program, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.program == nil {
+		return Program{}, false
+	}
+	return *s.program, true
+}
+
+// Advance progresses a running job by lines program lines. When the
+// last line is reached the job completes and the machine becomes
+// IDLE.
+func (s *Server) Advance(lines int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advanceJob(lines)
+}
+
+// advanceJob progresses the running job. The caller holds s.mu.
+func (s *Server) advanceJob(lines int) {
+	st := &s.state
+	if st.Status != "RUNNING" || st.TotalLines == 0 {
+		return
+	}
+	st.CurrentLine += lines
+	if st.CurrentLine >= st.TotalLines {
+		st.CurrentLine = st.TotalLines
+		st.Status = "IDLE"
+		st.PrintStatus = "Idle"
+		st.RemainingTime = 0
+	}
+	st.Progress = float64(st.CurrentLine) / float64(st.TotalLines)
+	st.ElapsedTime = int(st.Progress * st.EstimatedTime)
+	if st.Status == "RUNNING" {
+		st.RemainingTime = int(st.EstimatedTime) - st.ElapsedTime
+	}
+}
+
+// Token returns the token the fake currently accepts.
+func (s *Server) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// Pending returns the token of an unanswered pairing request, or ""
+// if there is none.
+func (s *Server) Pending() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Approve simulates the user accepting a pairing request on the
+// touchscreen. The pending token replaces the accepted token.
+func (s *Server) Approve() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == "" {
+		return
+	}
+	s.token, s.pending = s.pending, ""
+}
+
+// Reject simulates the user declining a pairing request on the
+// touchscreen.
+func (s *Server) Reject() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected, s.pending = s.pending, ""
+}
+
+// authorized confirms the request holds the expected token and,
+// other than for the connect API, that the client has connected. It
+// writes an error response when it does not.
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	tok := r.FormValue("token")
+	s.mu.Lock()
+	valid := tok == s.token && (s.connected || r.URL.Path == "/api/v1/connect")
+	s.mu.Unlock()
+	if !valid {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// reply writes v as a JSON response.
+func reply(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// replyOK writes a trivial success response.
+func replyOK(w http.ResponseWriter) {
+	reply(w, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.FormValue("token") == "" {
+		s.requestPairing(w)
+		return
+	}
+	if !s.authorized(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = true
+	reply(w, snappy.ConnectionResult{
+		Token:        s.token,
+		Series:       s.series,
+		HeadType:     s.toolID,
+		HasEnclosure: s.enclosure,
+	})
+}
+
+// requestPairing issues a new pending token, awaiting approval.
+func (s *Server) requestPairing(w http.ResponseWriter) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = true
+	h := hex.EncodeToString(b)
+	s.pending = fmt.Sprintf("%s-%s-%s-%s-%s", h[:8], h[8:12], h[12:16], h[16:20], h[20:])
+	reply(w, snappy.ConnectionResult{
+		Token:        s.pending,
+		Series:       s.series,
+		HeadType:     s.toolID,
+		HasEnclosure: s.enclosure,
+	})
+}
+
+func (s *Server) handleDisconnect(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = false
+	replyOK(w)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	tok := r.FormValue("token")
+	s.mu.Lock()
+	pending := tok == s.pending && tok != ""
+	rejected := tok == s.rejected && tok != ""
+	s.mu.Unlock()
+	if pending {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if rejected {
+		http.Error(w, "connection rejected", http.StatusForbidden)
+		return
+	}
+	if !s.authorized(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advanceJob(s.advance)
+	s.heat()
+	st := s.state
+	if st.Status == "STOPPED" {
+		// The stopped state is only reported once.
+		s.state.Status = "IDLE"
+		s.state.PrintStatus = "Idle"
+	}
+	reply(w, st)
+}
+
+// heat moves each simulated temperature with a target up to
+// s.heatRate degrees towards it, or straight to it if s.heatRate is
+// zero. Temperatures without a target are left alone. The caller
+// holds s.mu.
+func (s *Server) heat() {
+	st := &s.state
+	for _, t := range [][2]*float64{
+		{&st.NozzleTemperature, &st.NozzleTargetTemperature},
+		{&st.NozzleTemperature1, &st.NozzleTargetTemperature1},
+		{&st.NozzleTemperature2, &st.NozzleTargetTemperature2},
+		{&st.HeatedBedTemperature, &st.HeatedBedTargetTemperature},
+	} {
+		temp, target := t[0], *t[1]
+		if target == 0 {
+			continue
+		}
+		if d := target - *temp; s.heatRate == 0 || math.Abs(d) <= s.heatRate {
+			*temp = target
+		} else if d > 0 {
+			*temp += s.heatRate
+		} else {
+			*temp -= s.heatRate
+		}
+	}
+}
+
+// modules returns the simulated module listing. The caller holds s.mu.
+func (s *Server) modules() snappy.ModuleListing {
+	ms := snappy.ModuleListing{
+		ModuleList: []snappy.Module{{Key: 1, ModuleID: s.toolID, Status: true}},
+	}
+	if s.enclosure {
+		ms.ModuleList = append(ms.ModuleList, snappy.Module{Key: 2, ModuleID: 5, Status: true})
+	}
+	if s.estop != nil {
+		ms.ModuleList = append(ms.ModuleList, snappy.Module{Key: 3, ModuleID: 8, Status: true})
+	}
+	return ms
+}
+
+func (s *Server) handleModuleList(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reply(w, s.modules())
+}
+
+func (s *Server) handleModuleInfo(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := &s.state
+	mr := snappy.ModResult{ModuleInfo: []snappy.ModuleDetail{}}
+	th, _ := snappy.ToolHeadByID(s.toolID)
+	switch th.Kind {
+	case snappy.ToolLaser:
+		mr.ModuleInfo = append(mr.ModuleInfo, snappy.ModuleDetail{Key: 1, Module: &snappy.ModuleLaser{
+			LaserFocalLength: st.LaserFocalLength,
+			LaserPower:       st.LaserPower,
+			LaserCamera:      st.LaserCamera,
+		}})
+	case snappy.ToolCNC:
+		if th.ID == 15 {
+			mr.ModuleInfo = append(mr.ModuleInfo, snappy.ModuleDetail{Key: 1, Module: &snappy.ModuleCNC200W{
+				SpindleSpeed:       s.spindleRPM,
+				SpindleTargetSpeed: s.spindleRPM,
+				SpindleClockwise:   s.spindleCW,
+				SpindleTemperature: 25,
+			}})
+			break
+		}
+		mr.ModuleInfo = append(mr.ModuleInfo, snappy.ModuleDetail{Key: 1, Module: &snappy.ModuleCNC{
+			SpindleSpeed: s.spindleRPM,
+		}})
+	case snappy.ToolPrint:
+		if th.Nozzles == 2 {
+			mr.ModuleInfo = append(mr.ModuleInfo, snappy.ModuleDetail{Key: 1, Module: &snappy.Module3DDual{
+				NozzleTemperature1:       st.NozzleTemperature1,
+				NozzleTargetTemperature1: st.NozzleTargetTemperature1,
+				NozzleTemperature2:       st.NozzleTemperature2,
+				NozzleTargetTemperature2: st.NozzleTargetTemperature2,
+				IsFilamentOut:            st.IsFilamentOut != 0,
+			}})
+			break
+		}
+		mr.ModuleInfo = append(mr.ModuleInfo, snappy.ModuleDetail{Key: 1, Module: &snappy.Module3DBasic{
+			NozzleTemperature:       st.NozzleTemperature,
+			NozzleTargetTemperature: st.NozzleTargetTemperature,
+			IsFilamentOut:           st.IsFilamentOut != 0,
+		}})
+	}
+	if s.enclosure {
+		mr.ModuleInfo = append(mr.ModuleInfo, snappy.ModuleDetail{Key: 2, Module: &snappy.ModuleEnclosure{
+			IsReady:             s.enc.IsReady,
+			LED:                 s.enc.LED,
+			Fan:                 s.enc.Fan,
+			IsDoorEnabled:       s.enc.IsDoorEnabled,
+			IsEnclosureDoorOpen: st.IsEnclosureDoorOpen,
+			DoorSwitchCount:     st.DoorSwitchCount,
+		}})
+	}
+	if s.estop != nil {
+		mr.ModuleInfo = append(mr.ModuleInfo, snappy.ModuleDetail{Key: 3, Module: &snappy.ModuleEmergencyStop{
+			IsEmergencyStopped: *s.estop,
+		}})
+	}
+	reply(w, mr)
+}
+
+// setPercent parses a 0..100 form value into *val if present.
+func setPercent(r *http.Request, name string, val *int) error {
+	v := r.PostFormValue(name)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > 100 {
+		return fmt.Errorf("invalid %s=%q", name, v)
+	}
+	*val = n
+	return nil
+}
+
+func (s *Server) handleEnclosure(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.enclosure {
+		http.Error(w, "no enclosure", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodPost {
+		if err := setPercent(r, "led", &s.enc.LED); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := setPercent(r, "fan", &s.enc.Fan); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	reply(w, s.enc)
+}
+
+func (s *Server) handleExecuteCode(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, line := range strings.Split(r.PostFormValue("code"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := s.execute(line); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.codes = append(s.codes, line)
+	}
+	replyOK(w)
+}
+
+// execute simulates the effect of a single line of G-code. The
+// caller holds s.mu.
+func (s *Server) execute(line string) error {
+	fields := strings.Fields(line)
+	words := make(map[byte]float64)
+	for _, f := range fields[1:] {
+		if len(f) < 2 {
+			return fmt.Errorf("bad word %q in %q", f, line)
+		}
+		v, err := strconv.ParseFloat(f[1:], 64)
+		if err != nil {
+			return fmt.Errorf("bad word %q in %q: %v", f, line, err)
+		}
+		words[f[0]] = v
+	}
+	st := &s.state
+	pos := []*float64{&st.X, &st.Y, &st.Z}
+	off := []*float64{&st.OffsetX, &st.OffsetY, &st.OffsetZ}
+	switch fields[0] {
+	case "G0", "G1":
+		if f, ok := words['F']; ok {
+			s.feed = f
+		}
+		if e, ok := words['E']; ok {
+			if !s.relativeE {
+				return fmt.Errorf("absolute extrusion unsupported: %q", line)
+			}
+			s.extruded[s.extruder] += e
+		}
+		if !strings.ContainsAny(line[2:], "XYZ") {
+			// Extrusion without motion.
+			break
+		}
+		if !st.Homed {
+			return fmt.Errorf("not homed: %q", line)
+		}
+		for i, a := range []byte("XYZ") {
+			v, ok := words[a]
+			if !ok {
+				continue
+			}
+			if s.relative {
+				*pos[i] += v
+			} else {
+				*pos[i] = v
+			}
+		}
+	case "G28":
+		st.Homed = true
+		for i := range pos {
+			*pos[i], *off[i] = 0, 0
+		}
+	case "G90":
+		s.relative = false
+	case "G91":
+		s.relative = true
+	case "G92":
+		for i, a := range []byte("XYZ") {
+			v, ok := words[a]
+			if !ok {
+				continue
+			}
+			*off[i] += v - *pos[i]
+			*pos[i] = v
+		}
+	case "M3", "M4":
+		th, _ := snappy.ToolHeadByID(s.toolID)
+		if th.Kind == snappy.ToolCNC {
+			return s.spindle(th, fields[0] == "M3", words)
+		}
+		if st.Laser10WErrorState != 0 && words['P'] > 0 {
+			return fmt.Errorf("laser error state %d: %q", st.Laser10WErrorState, line)
+		}
+		st.LaserPower = words['P']
+	case "M5":
+		st.LaserPower = 0
+		s.spindleRPM = 0
+	case "M8":
+		s.airAssist = true
+	case "M9":
+		s.airAssist = false
+	case "M82":
+		s.relativeE = false
+	case "M83":
+		s.relativeE = true
+	case "T0", "T1":
+		s.extruder = int(fields[0][1] - '0')
+	case "M104":
+		t := words['S']
+		switch th, _ := snappy.ToolHeadByID(s.toolID); {
+		case th.Kind != snappy.ToolPrint:
+			return fmt.Errorf("no nozzle: %q", line)
+		case th.Nozzles == 1:
+			st.NozzleTargetTemperature = t
+		case words['T'] == 0:
+			st.NozzleTargetTemperature1 = t
+		default:
+			st.NozzleTargetTemperature2 = t
+		}
+	case "M140":
+		st.HeatedBedTargetTemperature = words['S']
+	case "G53", "M2002":
+		// Accepted without simulated effect.
+	default:
+		return fmt.Errorf("unsupported code %q", line)
+	}
+	return nil
+}
+
+// spindle simulates starting the spindle of the CNC tool head th,
+// following its SpindleClockwise and SpindlePercent capabilities.
+// The caller holds s.mu.
+func (s *Server) spindle(th snappy.ToolHead, cw bool, words map[byte]float64) error {
+	if th.SpindleClockwise && !cw {
+		return fmt.Errorf("%s spindle only turns clockwise", th.Name)
+	}
+	rpm := int(words['S'])
+	if th.SpindlePercent {
+		p, ok := words['P']
+		if !ok {
+			return fmt.Errorf("%s spindle requires P<percent>", th.Name)
+		}
+		rpm = int(p * float64(th.SpindleMax) / 100)
+	}
+	if rpm < th.SpindleMin || rpm > th.SpindleMax {
+		return fmt.Errorf("spindle speed %d out of range", rpm)
+	}
+	s.spindleRPM, s.spindleCW = rpm, cw
+	return nil
+}
+
+func (s *Server) handlePreparePrint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(w, r) {
+		return
+	}
+	f, hdr, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.program = &Program{
+		Name:   hdr.Filename,
+		Type:   r.FormValue("type"),
+		Data:   data,
+		Length: r.ContentLength,
+	}
+	replyOK(w)
+}
+
+func (s *Server) handleStartPrint(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.program == nil {
+		http.Error(w, "no program prepared", http.StatusBadRequest)
+		return
+	}
+	if s.state.Status != "IDLE" {
+		http.Error(w, "machine busy", http.StatusConflict)
+		return
+	}
+	st := &s.state
+	st.Status = "RUNNING"
+	st.PrintStatus = "Printing"
+	st.FileName = s.program.Name
+	st.TotalLines = bytes.Count(s.program.Data, []byte("\n"))
+	if st.TotalLines == 0 {
+		st.TotalLines = 1
+	}
+	st.CurrentLine = 0
+	st.Progress = 0
+	st.EstimatedTime = float64(st.TotalLines)
+	st.ElapsedTime = 0
+	st.RemainingTime = int(st.EstimatedTime)
+	replyOK(w)
+}
+
+// jobControl returns a handler that transitions a job from the from
+// status (any status if "") to the to status.
+func (s *Server) jobControl(from, to, printing string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(w, r) {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		st := &s.state
+		if st.Status == "IDLE" || (from != "" && st.Status != from) {
+			http.Error(w, fmt.Sprintf("cannot change %s to %s", st.Status, to), http.StatusConflict)
+			return
+		}
+		st.Status = to
+		st.PrintStatus = printing
+		replyOK(w)
+	}
+}
+
+// photo generates a small JPEG image tagged by index.
+func photo(index int) ([]byte, error) {
+	im := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range im.Pix {
+		im.Pix[i] = uint8(16 * index)
+	}
+	im.SetGray(32, 24, color.Gray{Y: 255})
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, im, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	var vals [4]float64
+	for i, name := range []string{"index", "x", "y", "z"} {
+		v, err := strconv.ParseFloat(r.FormValue(name), 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad %s: %v", name, err), http.StatusBadRequest)
+			return
+		}
+		vals[i] = v
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.state.LaserCamera {
+		http.Error(w, "no camera", http.StatusNotFound)
+		return
+	}
+	index := int(vals[0])
+	d, err := photo(index)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if f, err := strconv.ParseFloat(r.FormValue("feedRate"), 64); err == nil {
+		s.feed = f
+	}
+	s.state.X, s.state.Y, s.state.Z = vals[1], vals[2], vals[3]
+	s.photos[index] = d
+	replyOK(w)
+}
+
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.FormValue("index"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	d, ok := s.photos[index]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "no such image", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(d)
+}
@@ -0,0 +1,235 @@
+package snappy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MachineState is the overall state of the machine, as reported in
+// StatusResult.Status.
+type MachineState int
+
+// StateUnknown etc are the known values of MachineState.
+const (
+	StateUnknown MachineState = iota
+	StateIdle
+	StateRunning
+	StatePaused
+	StateStopped
+)
+
+// machineStates holds the A350 names of the machine states.
+var machineStates = map[MachineState]string{
+	StateIdle:    "IDLE",
+	StateRunning: "RUNNING",
+	StatePaused:  "PAUSED",
+	StateStopped: "STOPPED",
+}
+
+// String returns the A350 name for the machine state.
+func (s MachineState) String() string {
+	if name, ok := machineStates[s]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// ParseMachineState converts an A350 status string into a
+// MachineState. Unrecognized strings yield StateUnknown.
+func ParseMachineState(status string) MachineState {
+	for s, name := range machineStates {
+		if strings.EqualFold(name, status) {
+			return s
+		}
+	}
+	return StateUnknown
+}
+
+// machineTransitions holds the single step state transitions learned
+// from the A350. A machine stays IDLE until a job is started. A job
+// runs to completion (IDLE), or can be paused or stopped. A stopped
+// job returns to IDLE.
+var machineTransitions = map[MachineState][]MachineState{
+	StateIdle:    {StateRunning},
+	StateRunning: {StatePaused, StateStopped, StateIdle},
+	StatePaused:  {StateRunning, StateStopped, StateIdle},
+	StateStopped: {StateIdle},
+}
+
+// CanTransition indicates that the machine can legitimately move
+// from state s to state next in a single step. Remaining in the same
+// state, and any transition involving StateUnknown, are considered
+// legal.
+func (s MachineState) CanTransition(next MachineState) bool {
+	if s == next || s == StateUnknown || next == StateUnknown {
+		return true
+	}
+	return slices.Contains(machineTransitions[s], next)
+}
+
+// machineJobStates holds the job state the A350 reports with each
+// machine state of a job in progress.
+var machineJobStates = map[MachineState]JobState{
+	StateRunning: JobPrinting,
+	StatePaused:  JobPaused,
+	StateStopped: JobStopped,
+}
+
+// skippedPoll indicates that a transition from state s to the state
+// of st, illegal in a single step, passed through one state between
+// two status polls, for example IDLE to PAUSED for a job started and
+// paused between them. The job state of st must confirm that a job
+// reached its machine state.
+func (s MachineState) skippedPoll(st StatusResult) bool {
+	next := st.State()
+	if js, ok := machineJobStates[next]; !ok || st.JobState() != js {
+		return false
+	}
+	for _, t := range machineTransitions[s] {
+		if slices.Contains(machineTransitions[t], next) {
+			return true
+		}
+	}
+	return false
+}
+
+// JobState is the state of the current job, as reported in
+// StatusResult.PrintStatus.
+type JobState int
+
+// JobUnknown etc are the known values of JobState.
+const (
+	JobUnknown JobState = iota
+	JobIdle
+	JobPrinting
+	JobPaused
+	JobStopped
+)
+
+// jobStates holds the A350 names of the job states.
+var jobStates = map[JobState]string{
+	JobIdle:     "Idle",
+	JobPrinting: "Printing",
+	JobPaused:   "Paused",
+	JobStopped:  "Stopped",
+}
+
+// String returns the A350 name for the job state.
+func (s JobState) String() string {
+	if name, ok := jobStates[s]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// ParseJobState converts an A350 print status string into a
+// JobState. Unrecognized strings yield JobUnknown.
+func ParseJobState(status string) JobState {
+	for s, name := range jobStates {
+		if strings.EqualFold(name, status) {
+			return s
+		}
+	}
+	return JobUnknown
+}
+
+// State returns the typed machine state of the status.
+func (st StatusResult) State() MachineState {
+	return ParseMachineState(st.Status)
+}
+
+// JobState returns the typed job state of the status.
+func (st StatusResult) JobState() JobState {
+	return ParseJobState(st.PrintStatus)
+}
+
+// TransitionError is returned when an illegal machine state
+// transition is observed while awaiting some state.
+type TransitionError struct {
+	From, To MachineState
+}
+
+// Error describes the unexpected state transition.
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("unexpected machine state transition %v -> %v", e.From, e.To)
+}
+
+// State returns the cached machine state from the most recent status
+// update.
+func (c *Conn) State() MachineState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.toolState.State()
+}
+
+// AwaitFunc waits until done returns true for the cached status. The
+// status is reconsidered after each status update. A timeout of zero
+// waits indefinitely, otherwise ErrTimeout is returned when it
+// expires. If an illegal machine state transition is observed while
+// waiting, a *TransitionError is returned. A transition through one
+// state missed between status polls is accepted when the reported
+// job state confirms it.
+func (c *Conn) AwaitFunc(ctx context.Context, timeout time.Duration, done func(st StatusResult) bool) (StatusResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	events := c.Subscribe(ctx)
+	c.mu.Lock()
+	st := c.toolState
+	c.mu.Unlock()
+	was := st.State()
+	for {
+		now := st.State()
+		if !was.CanTransition(now) && !was.skippedPoll(st) {
+			return st, &TransitionError{From: was, To: now}
+		}
+		was = now
+		if done(st) {
+			return st, nil
+		}
+		select {
+		case _, ok := <-events:
+			if !ok {
+				// Subscriptions also end when ctx is canceled.
+				if ctx.Err() != nil {
+					return st, ErrCanceled
+				}
+				return st, ErrNotConnected
+			}
+		case <-expired:
+			return st, fmt.Errorf("%w after %v in state %v", ErrTimeout, timeout, st.State())
+		case <-ctx.Done():
+			return st, ErrCanceled
+		}
+		c.mu.Lock()
+		st = c.toolState
+		c.mu.Unlock()
+	}
+}
+
+// AwaitState waits until the machine is in any of the listed
+// states, returning the state reached. See AwaitFunc for the
+// meaning of timeout and the errors returned.
+func (c *Conn) AwaitState(ctx context.Context, timeout time.Duration, states ...MachineState) (MachineState, error) {
+	if len(states) == 0 {
+		return StateUnknown, ErrInvalid
+	}
+	st, err := c.AwaitFunc(ctx, timeout, func(st StatusResult) bool {
+		now := st.State()
+		for _, s := range states {
+			if now == s {
+				return true
+			}
+		}
+		return false
+	})
+	return st.State(), err
+}
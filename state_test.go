@@ -0,0 +1,142 @@
+package snappy_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+)
+
+func TestParseStates(t *testing.T) {
+	for _, s := range []snappy.MachineState{snappy.StateIdle, snappy.StateRunning, snappy.StatePaused, snappy.StateStopped} {
+		if got := snappy.ParseMachineState(s.String()); got != s {
+			t.Errorf("ParseMachineState(%q) = %v", s.String(), got)
+		}
+	}
+	if got := snappy.ParseMachineState("WIBBLE"); got != snappy.StateUnknown {
+		t.Errorf("ParseMachineState(WIBBLE) = %v", got)
+	}
+	for _, s := range []snappy.JobState{snappy.JobIdle, snappy.JobPrinting, snappy.JobPaused, snappy.JobStopped} {
+		if got := snappy.ParseJobState(s.String()); got != s {
+			t.Errorf("ParseJobState(%q) = %v", s.String(), got)
+		}
+	}
+	vs := []struct {
+		from, to snappy.MachineState
+		ok       bool
+	}{
+		{snappy.StateIdle, snappy.StateRunning, true},
+		{snappy.StateRunning, snappy.StateIdle, true},
+		{snappy.StatePaused, snappy.StateRunning, true},
+		{snappy.StateStopped, snappy.StateIdle, true},
+		{snappy.StateUnknown, snappy.StatePaused, true},
+		{snappy.StateIdle, snappy.StatePaused, false},
+		{snappy.StateIdle, snappy.StateStopped, false},
+		{snappy.StateStopped, snappy.StateRunning, false},
+		{snappy.StateStopped, snappy.StatePaused, false},
+	}
+	for i, v := range vs {
+		if got := v.from.CanTransition(v.to); got != v.ok {
+			t.Errorf("[%d] %v.CanTransition(%v) = %v want %v", i, v.from, v.to, got, v.ok)
+		}
+	}
+}
+
+func TestAwaitState(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+
+	if _, err := c.AwaitState(ctx, 50*time.Millisecond, snappy.StateRunning); !errors.Is(err, snappy.ErrTimeout) {
+		t.Errorf("AwaitState got %v want %v", err, snappy.ErrTimeout)
+	}
+	if st, err := c.AwaitState(ctx, time.Second, snappy.StateIdle, snappy.StateStopped); err != nil || st != snappy.StateIdle {
+		t.Errorf("AwaitState got %v, %v", st, err)
+	}
+
+	if err := c.RunProgram("job.nc", []byte("G0 X1\nG0 X2\n")); err != nil {
+		t.Fatalf("RunProgram failed: %v", err)
+	}
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if st := c.State(); st != snappy.StateRunning {
+		t.Fatalf("got state %v want %v", st, snappy.StateRunning)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := c.AwaitState(ctx, 5*time.Second, snappy.StateIdle)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Advance(2)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("AwaitState failed: %v", err)
+	}
+
+	s.Update(func(st *snappy.StatusResult) { st.Status = "STOPPED" })
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	go func() {
+		_, err := c.AwaitState(context.Background(), 5*time.Second, snappy.StateRunning)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Update(func(st *snappy.StatusResult) { st.Status = "PAUSED" })
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	var te *snappy.TransitionError
+	if err := <-done; !errors.As(err, &te) || te.From != snappy.StateStopped || te.To != snappy.StatePaused {
+		t.Errorf("AwaitState got %v, want STOPPED -> PAUSED transition error", err)
+	}
+
+	// A job started and paused between polls skips RUNNING, which
+	// the job state confirms.
+	for _, v := range []struct {
+		job string
+		ok  bool
+	}{
+		{"Paused", true},
+		{"Idle", false},
+	} {
+		s.Update(func(st *snappy.StatusResult) { st.Status, st.PrintStatus = "IDLE", "Idle" })
+		if err := c.Status(); err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+		go func() {
+			_, err := c.AwaitState(ctx, 5*time.Second, snappy.StatePaused)
+			done <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		s.Update(func(st *snappy.StatusResult) { st.Status, st.PrintStatus = "PAUSED", v.job })
+		if err := c.Status(); err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+		if err := <-done; (err == nil) != v.ok || (err != nil && !errors.As(err, &te)) {
+			t.Errorf("IDLE -> PAUSED with job %s got %v", v.job, err)
+		}
+	}
+}
+
+func TestAwaitCanceled(t *testing.T) {
+	ctx, _, c := connect(t)
+	for i := 0; i < 20; i++ {
+		actx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			_, err := c.AwaitState(actx, 0, snappy.StateRunning)
+			done <- err
+		}()
+		time.Sleep(time.Millisecond)
+		cancel()
+		if err := <-done; !errors.Is(err, snappy.ErrCanceled) {
+			t.Fatalf("[%d] canceled AwaitState got %v, want %v", i, err, snappy.ErrCanceled)
+		}
+	}
+}
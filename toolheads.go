@@ -0,0 +1,202 @@
+package snappy
+
+import "fmt"
+
+// ToolKind classifies what a tool head does.
+type ToolKind int
+
+const (
+	ToolUnknown ToolKind = iota
+	ToolPrint
+	ToolLaser
+	ToolCNC
+)
+
+// String returns the name the machine uses for the kind of job a
+// tool head runs. This is the "type" value of a program upload.
+func (k ToolKind) String() string {
+	switch k {
+	case ToolPrint:
+		return "3DP"
+	case ToolLaser:
+		return "Laser"
+	case ToolCNC:
+		return "CNC"
+	default:
+		return "Unknown"
+	}
+}
+
+// ToolHead describes the capabilities of a kind of tool head.
+type ToolHead struct {
+	// ID is the ModuleNames id of the tool head.
+	ID int
+
+	// Kind is the kind of work the tool head performs.
+	Kind ToolKind
+
+	// Name is the name used in the header of the *nc files for
+	// the tool head.
+	Name string
+
+	// Report is the StatusResult.ToolHead value reported while
+	// the tool head is attached.
+	Report string
+
+	// Power is the rated power of the tool head in W. For laser
+	// heads this is the optical output power, for CNC heads it
+	// is the spindle motor power.
+	Power float64
+
+	// Camera indicates the tool head has a built-in camera.
+	Camera bool
+
+	// SafetyLock indicates the laser of the tool head reports a
+	// safety lock, and errors, in StatusResult.Laser10WErrorState.
+	// It does not emit light while any are reported. The lock is
+	// only detected, and is unlocked on the touchscreen.
+	SafetyLock bool
+
+	// SpindleMin and SpindleMax bound the spindle speed in RPM of
+	// CNC tool heads.
+	SpindleMin, SpindleMax int
+
+	// SpindleClockwise indicates the spindle only turns clockwise,
+	// and SpindlePercent that its speed is set as a percentage of
+	// SpindleMax rather than in RPM.
+	SpindleClockwise, SpindlePercent bool
+
+	// Nozzles is the number of extruders of a 3D printing head.
+	Nozzles int
+
+	// Ext is the file extension used for programs for this tool
+	// head.
+	Ext string
+
+	// NeedsHeader indicates programs for this tool head must start
+	// with a header naming it. See NewHeader.
+	NeedsHeader bool
+
+	// Clearance is the height, in mm, the tool head is kept above
+	// the work piece while traveling. See Conn.SafeZ.
+	Clearance float64
+}
+
+// ToolHeads lists the known tool heads. Only the Report value of the
+// 1.6W laser head has been observed on a real machine; the others
+// follow the same pattern, but are unconfirmed.
+var ToolHeads = []ToolHead{
+	{
+		ID:        0,
+		Kind:      ToolPrint,
+		Name:      ModuleNames[0],
+		Report:    "TOOLHEAD_3DPRINTING_1",
+		Nozzles:   1,
+		Ext:       ".gcode",
+		Clearance: 5,
+	},
+	{
+		ID:               1,
+		Kind:             ToolCNC,
+		Name:             ModuleNames[1],
+		Report:           "TOOLHEAD_CNC_1",
+		Power:            50,
+		SpindleMin:       6000,
+		SpindleMax:       12000,
+		SpindleClockwise: true,
+		SpindlePercent:   true,
+		Ext:              ".cnc",
+		Clearance:        10,
+	},
+	{
+		ID:        2,
+		Kind:      ToolLaser,
+		Name:      ModuleNames[2],
+		Report:    "TOOLHEAD_LASER_1",
+		Power:     1.6,
+		Camera:    true,
+		Ext:       ".nc",
+		Clearance: 5,
+	},
+	{
+		ID:         14,
+		Kind:       ToolLaser,
+		Name:       ModuleNames[14],
+		Report:     "TOOLHEAD_LASER_2",
+		Power:      10,
+		Camera:     true,
+		SafetyLock: true,
+		Ext:        ".nc",
+		Clearance:  5,
+	},
+	{
+		ID:         15,
+		Kind:       ToolCNC,
+		Name:       ModuleNames[15],
+		Report:     "TOOLHEAD_CNC_2",
+		Power:      200,
+		SpindleMin: 8000,
+		SpindleMax: 18000,
+		Ext:        ".cnc",
+		Clearance:  10,
+	},
+	{
+		ID:        18,
+		Kind:      ToolPrint,
+		Name:      ModuleNames[18],
+		Report:    "TOOLHEAD_3DPRINTING_2",
+		Nozzles:   2,
+		Ext:       ".gcode",
+		Clearance: 5,
+	},
+	{
+		ID:          23,
+		Kind:        ToolLaser,
+		Name:        ModuleNames[23],
+		Report:      "TOOLHEAD_LASER_3",
+		Power:       2,
+		Ext:         ".nc",
+		NeedsHeader: true,
+		Clearance:   5,
+	},
+}
+
+// ToolHeadByID returns the tool head with the ModuleNames id.
+func ToolHeadByID(id int) (ToolHead, error) {
+	for _, th := range ToolHeads {
+		if th.ID == id {
+			return th, nil
+		}
+	}
+	return ToolHead{}, fmt.Errorf("unknown tool head id=%d", id)
+}
+
+// ToolHeadByReport returns the tool head that reports itself with
+// the StatusResult.ToolHead value report.
+func ToolHeadByReport(report string) (ToolHead, error) {
+	for _, th := range ToolHeads {
+		if th.Report == report {
+			return th, nil
+		}
+	}
+	return ToolHead{}, fmt.Errorf("unknown tool head %q", report)
+}
+
+// Tool returns the description of the attached tool head. It is
+// identified by the most recent status, or failing that, by the
+// key=1 module observed at connection time.
+func (c *Conn) Tool() (ToolHead, error) {
+	c.mu.Lock()
+	report := c.toolState.ToolHead
+	c.mu.Unlock()
+	if report != "" {
+		if th, err := ToolHeadByReport(report); err == nil {
+			return th, nil
+		}
+	}
+	id, _, err := c.ToolHead(1)
+	if err != nil {
+		return ToolHead{}, err
+	}
+	return ToolHeadByID(id)
+}
@@ -0,0 +1,59 @@
+package snappy_test
+
+import (
+	"testing"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+)
+
+func TestToolHeads(t *testing.T) {
+	for _, th := range snappy.ToolHeads {
+		if th.Name != snappy.ModuleNames[th.ID] {
+			t.Errorf("id=%d name %q != ModuleNames %q", th.ID, th.Name, snappy.ModuleNames[th.ID])
+		}
+		if got, err := snappy.ToolHeadByReport(th.Report); err != nil || got.ID != th.ID {
+			t.Errorf("ToolHeadByReport(%q) got %d, %v want %d", th.Report, got.ID, err, th.ID)
+		}
+		if th.Kind == snappy.ToolCNC && (th.SpindleMin <= 0 || th.SpindleMax <= th.SpindleMin) {
+			t.Errorf("id=%d bad spindle range %d..%d", th.ID, th.SpindleMin, th.SpindleMax)
+		}
+		if th.Kind == snappy.ToolPrint && th.Nozzles == 0 {
+			t.Errorf("id=%d printing head without nozzles", th.ID)
+		}
+	}
+	if _, err := snappy.ToolHeadByID(519); err == nil {
+		t.Error("Quick Swap Kit reported as a tool head")
+	}
+
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+	if err := c.SetPollInterval(20 * time.Millisecond); err != nil {
+		t.Fatalf("SetPollInterval failed: %v", err)
+	}
+	for _, tc := range []struct {
+		id   int
+		kind string
+	}{{1, "CNC"}, {15, "CNC"}, {0, "3DP"}, {18, "3DP"}, {14, "Laser"}} {
+		s.SetToolHead(tc.id)
+		if err := c.Status(); err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+		th, err := c.Tool()
+		if err != nil || th.ID != tc.id {
+			t.Fatalf("Tool got %d, %v want %d", th.ID, err, tc.id)
+		}
+		if err := c.RunProgram("job"+th.Ext, []byte("G0 X1\n")); err != nil {
+			t.Fatalf("RunProgram failed: %v", err)
+		}
+		if p, _ := s.Program(); p.Type != tc.kind {
+			t.Errorf("id=%d uploaded as %q want %q", tc.id, p.Type, tc.kind)
+		}
+		if err := c.StopProgram(); err != nil {
+			t.Fatalf("StopProgram failed: %v", err)
+		}
+		if _, err := c.AwaitState(ctx, 0, snappy.StateIdle); err != nil {
+			t.Fatalf("AwaitState failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package snappy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// UploadProgress describes how much of a program has been uploaded.
+type UploadProgress struct {
+	// Sent is the number of bytes of the program sent so far, and
+	// Total the size of the program, or 0 if it is not known.
+	Sent, Total int64
+
+	// Rate is the average rate, in bytes per second, of the upload
+	// so far.
+	Rate float64
+
+	// ETA estimates the time remaining to send the rest of the
+	// program, or is 0 if Total is not known.
+	ETA time.Duration
+}
+
+// UploadOption adjusts a single call of UploadProgram,
+// UploadProgramFrom or RunProgramContext. See WithProgress and
+// WithSize.
+type UploadOption func(*uploadOptions)
+
+// uploadOptions holds the adjustments made by UploadOptions.
+type uploadOptions struct {
+	progress func(UploadProgress)
+	interval time.Duration
+	size     int64
+}
+
+// WithProgress calls fn as the program is sent, at most every
+// interval and once more when all of it has been sent. The calls are
+// made from another goroutine, but not after the upload returns.
+func WithProgress(interval time.Duration, fn func(UploadProgress)) UploadOption {
+	return func(o *uploadOptions) {
+		o.progress, o.interval = fn, interval
+	}
+}
+
+// WithSize sets the size of the program read by UploadProgramFrom,
+// which must be exact. It is sent as the length of the upload, which
+// is otherwise sent chunked, and is the Total of its UploadProgress.
+// Without it, the size is known only for files and readers with a
+// Len method, such as strings.Reader.
+func WithSize(n int64) UploadOption {
+	return func(o *uploadOptions) {
+		o.size = n
+	}
+}
+
+// UploadProgram uploads a program to the controller without running
+// it. It can then be inspected on the touchscreen and started there,
+// or with StartPreparedProgram. The upload is abandoned if ctx is
+// canceled.
+//
+// The controller prepares a single program at a time: uploading a
+// program replaces the one previously prepared. No request is known
+// to list or delete the other files held by the controller, so the
+// files copied to it by other means can only be managed from its
+// touchscreen.
+func (c *Conn) UploadProgram(ctx context.Context, name string, data []byte, opts ...UploadOption) error {
+	hdr := c.programHeader(data)
+	opts = append(opts, WithSize(int64(len(hdr)+len(data))))
+	return c.upload(ctx, name, bytes.NewReader(data), hdr, opts)
+}
+
+// UploadProgramFrom uploads a program read from r until io.EOF, as
+// UploadProgram does. The program is streamed to the controller, not
+// held in memory, except for a program without a header for a tool
+// head that NeedsHeader: it is read in full to generate one. A
+// program of unknown size, see WithSize, is sent chunked. It returns
+// once the last read of r has returned.
+func (c *Conn) UploadProgramFrom(ctx context.Context, name string, r io.Reader, opts ...UploadOption) error {
+	var size int64
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		size = int64(v.Len())
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if fi, err := v.Stat(); err == nil && fi.Mode().IsRegular() {
+			size = fi.Size()
+			// Only the rest of a file already partly read is sent.
+			if sk, ok := r.(io.Seeker); ok {
+				if off, err := sk.Seek(0, io.SeekCurrent); err == nil {
+					size -= off
+				}
+			}
+		}
+	}
+	if size != 0 {
+		opts = append([]UploadOption{WithSize(size)}, opts...)
+	}
+
+	br := bufio.NewReader(r)
+	if th, err := c.Tool(); err == nil && th.NeedsHeader {
+		// A full buffer, or a short one at io.EOF, holds enough of the
+		// program to find a header.
+		if peek, _ := br.Peek(br.Size()); !HasHeader(peek) {
+			data, err := io.ReadAll(br)
+			if err != nil {
+				return fmt.Errorf("unable to read %q: %v", name, err)
+			}
+			return c.UploadProgram(ctx, name, data, opts...)
+		}
+	}
+	return c.upload(ctx, name, br, nil, opts)
+}
+
+// programHeader returns the header to prefix to the program data for
+// the attached tool head, or nil if none is needed.
+func (c *Conn) programHeader(data []byte) []byte {
+	th, err := c.Tool()
+	if err != nil || !th.NeedsHeader || HasHeader(data) {
+		return nil
+	}
+	hdr := &bytes.Buffer{}
+	NewHeader(th, c.Model(), data).WriteTo(hdr)
+	return hdr.Bytes()
+}
+
+// upload sends the program, prefixed by hdr, to be prepared by the
+// controller. The program is written through a pipe as the request
+// is sent, so only the part of it being sent is in memory. When the
+// size of the program is known the request has a Content-Length, and
+// otherwise it is sent chunked. The upload is not limited by the
+// Options.Timeout, only by ctx.
+func (c *Conn) upload(ctx context.Context, name string, program io.Reader, hdr []byte, opts []UploadOption) error {
+	var o uploadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	content := ToolLaser.String()
+	if th, err := c.Tool(); err == nil && th.Kind != ToolUnknown {
+		content = th.Kind.String()
+	}
+	head, tail, contentType, err := c.programForm(name, content)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pw.CloseWithError(sendProgram(ctx, pw, io.MultiReader(bytes.NewReader(hdr), program), o))
+	}()
+	// Closing the reader ends the writer if the request fails
+	// before reading the whole body.
+	defer wg.Wait()
+	defer pr.Close()
+
+	body := io.MultiReader(bytes.NewReader(head), pr, bytes.NewReader(tail))
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/prepare_print", contentType, body)
+	if err != nil {
+		return err
+	}
+	if o.size > 0 {
+		req.ContentLength = int64(len(head)) + o.size + int64(len(tail))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("upload of %q abandoned: %w", name, ctx.Err())
+		}
+		return err
+	}
+	result, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("prep failed with %s: %v", string(result), err)
+		return fmt.Errorf("unable to prepare %q: %s", name, resp.Status)
+	}
+	return nil
+}
+
+// programForm returns the multipart form of a prepare_print request
+// with the program content type, as the head preceding the program
+// data and the tail following it, and the content type of the form.
+func (c *Conn) programForm(name, content string) (head, tail []byte, contentType string, err error) {
+	buf := &bytes.Buffer{}
+	wr := multipart.NewWriter(buf)
+	if err := wr.WriteField("token", c.token); err != nil {
+		return nil, nil, "", err
+	}
+	if err := wr.WriteField("type", content); err != nil {
+		return nil, nil, "", err
+	}
+	mh := make(textproto.MIMEHeader)
+	mh.Set("Content-Disposition", fmt.Sprintf("form-data; name=\"file\"; filename=%q", filepath.Base(name)))
+	mh.Set("Content-Type", "application/octet-stream")
+	if _, err := wr.CreatePart(mh); err != nil {
+		return nil, nil, "", err
+	}
+	head = bytes.Clone(buf.Bytes())
+	buf.Reset()
+	if err := wr.Close(); err != nil {
+		return nil, nil, "", err
+	}
+	return head, buf.Bytes(), wr.FormDataContentType(), nil
+}
+
+// sendProgram copies the program data to w, reporting the progress
+// to the progress function of o.
+func sendProgram(ctx context.Context, w io.Writer, program io.Reader, o uploadOptions) error {
+	pw := &progressWriter{ctx: ctx, w: w, opts: o, start: time.Now()}
+	if _, err := io.Copy(pw, program); err != nil {
+		return err
+	}
+	if pw.reported != pw.sent || pw.last.IsZero() {
+		pw.report()
+	}
+	return nil
+}
+
+// progressWriter counts the program bytes written to w, reporting
+// the progress to the progress function of opts. It fails once ctx
+// is canceled.
+type progressWriter struct {
+	ctx         context.Context
+	w           io.Writer
+	opts        uploadOptions
+	start, last time.Time
+
+	// sent counts the bytes written, and reported those at the
+	// last report.
+	sent, reported int64
+}
+
+// progressChunk is the most written to w at once, so that readers
+// writing the whole program in one call, such as strings.Reader,
+// still report progress.
+const progressChunk = 32 << 10
+
+// Write writes b to w, reporting the progress whenever the interval
+// has passed since the last report.
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if err := pw.ctx.Err(); err != nil {
+			return written, err
+		}
+		chunk := b[:min(len(b), progressChunk)]
+		n, err := pw.w.Write(chunk)
+		written += n
+		pw.sent += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if time.Since(pw.last) >= pw.opts.interval {
+			pw.report()
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// report calls the progress function, if any, with the progress of
+// the upload.
+func (pw *progressWriter) report() {
+	if pw.opts.progress == nil {
+		return
+	}
+	pw.last, pw.reported = time.Now(), pw.sent
+	p := UploadProgress{Sent: pw.sent, Total: pw.opts.size}
+	if d := pw.last.Sub(pw.start).Seconds(); d > 0 {
+		p.Rate = float64(p.Sent) / d
+	}
+	if p.Total > p.Sent && p.Rate > 0 {
+		p.ETA = time.Duration(float64(p.Total-p.Sent) / p.Rate * float64(time.Second))
+	}
+	pw.opts.progress(p)
+}
+
+// StartPreparedProgram runs the program most recently uploaded to the
+// controller. The machine must be idle, and a laser with a
+// SafetyLock must report no errors.
+func (c *Conn) StartPreparedProgram(ctx context.Context) error {
+	if err := c.laserReady(ctx); err != nil {
+		return err
+	}
+	v := url.Values{}
+	v.Set("token", c.token)
+	resp, err := c.postForm(ctx, "/api/v1/start_print", v)
+	if err != nil {
+		return err
+	}
+	result, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("run failed with %s: %v", string(result), err)
+		return fmt.Errorf("unable to start program: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package snappy_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zappem.net/pub/net/snappy"
+)
+
+func TestUploadProgram(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+
+	if err := c.StartPreparedProgram(ctx); err == nil {
+		t.Error("StartPreparedProgram succeeded with no program uploaded")
+	}
+
+	data := []byte("G0 X1 Y1\nG0 X2 Y2\n")
+	if err := c.UploadProgram(ctx, "/tmp/job.nc", data); err != nil {
+		t.Fatalf("UploadProgram failed: %v", err)
+	}
+	p, ok := s.Program()
+	if !ok || p.Name != "job.nc" || p.Type != "Laser" || !bytes.Equal(p.Data, data) {
+		t.Errorf("got program %q (%s) %q", p.Name, p.Type, p.Data)
+	}
+	if p.Length <= int64(len(data)) {
+		t.Errorf("got upload length %d for %d bytes", p.Length, len(data))
+	}
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if ok, status := c.Running(); ok {
+		t.Fatalf("uploaded program is running: %s", status)
+	}
+
+	// The prepared program can be run more than once.
+	for i := 0; i < 2; i++ {
+		if err := c.StartPreparedProgram(ctx); err != nil {
+			t.Fatalf("StartPreparedProgram %d failed: %v", i, err)
+		}
+		if err := c.Status(); err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+		if ok, status := c.Running(); !ok {
+			t.Fatalf("run %d not running: %s", i, status)
+		}
+		if err := c.StartPreparedProgram(ctx); err == nil {
+			t.Errorf("run %d started again while running", i)
+		}
+		s.Advance(2)
+		if err := c.Status(); err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+		if ok, status := c.Running(); ok {
+			t.Fatalf("run %d still running: %s", i, status)
+		}
+	}
+}
+
+// failReader reads its data then fails.
+type failReader struct {
+	io.Reader
+}
+
+var errRead = errors.New("read failed")
+
+func (f failReader) Read(b []byte) (int, error) {
+	n, err := f.Reader.Read(b)
+	if err == io.EOF {
+		err = errRead
+	}
+	return n, err
+}
+
+func TestUploadProgramFrom(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+	s.SetToolHead(0)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+
+	// Larger than any buffer of the upload.
+	data := strings.Repeat("G1 X10 Y10 E1\nG1 X20 Y20 E2\n", 1<<14)
+	if err := c.UploadProgramFrom(ctx, "part.gcode", strings.NewReader(data)); err != nil {
+		t.Fatalf("UploadProgramFrom failed: %v", err)
+	}
+	p, _ := s.Program()
+	if p.Name != "part.gcode" || p.Type != "3DP" || string(p.Data) != data {
+		t.Errorf("got program %q (%s) of %d bytes, want %d", p.Name, p.Type, len(p.Data), len(data))
+	}
+
+	r := failReader{strings.NewReader("G0 X1\n")}
+	if err := c.UploadProgramFrom(ctx, "bad.gcode", r); err == nil {
+		t.Error("UploadProgramFrom succeeded with a failing reader")
+	}
+	if p, _ := s.Program(); p.Name != "part.gcode" {
+		t.Errorf("failed upload replaced the program with %q", p.Name)
+	}
+
+	// Only the unread rest of a file is sent, with its length.
+	name := filepath.Join(t.TempDir(), "rest.gcode")
+	if err := os.WriteFile(name, []byte("G28\nG0 X1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UploadProgramFrom(ctx, name, f); err != nil {
+		t.Fatalf("UploadProgramFrom file failed: %v", err)
+	}
+	if p, _ := s.Program(); string(p.Data) != "G0 X1\n" || p.Length == -1 {
+		t.Errorf("got program %q of upload length %d", p.Data, p.Length)
+	}
+
+	// The IR laser needs a header, added to a program without one.
+	s.SetToolHead(23)
+	if err := c.Status(); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	ir := "G0 X1 Y1\nG1 X2 Y2\n"
+	if err := c.UploadProgramFrom(ctx, "ir.nc", strings.NewReader(ir)); err != nil {
+		t.Fatalf("UploadProgramFrom failed: %v", err)
+	}
+	if p, _ := s.Program(); !snappy.HasHeader(p.Data) || !bytes.HasSuffix(p.Data, []byte(ir)) {
+		t.Errorf("got IR program:\n%s", p.Data)
+	}
+}
+
+func TestUploadProgress(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+
+	data := strings.Repeat("G1 X10 Y10\nG1 X20 Y20\n", 1<<14)
+	for _, v := range []struct {
+		name  string
+		r     io.Reader
+		opts  []snappy.UploadOption
+		total int64
+	}{
+		{name: "len", r: strings.NewReader(data), total: int64(len(data))},
+		{name: "unknown", r: io.MultiReader(strings.NewReader(data))},
+		{name: "sized", r: io.MultiReader(strings.NewReader(data)), opts: []snappy.UploadOption{snappy.WithSize(int64(len(data)))}, total: int64(len(data))},
+	} {
+		var ps []snappy.UploadProgress
+		opts := append(v.opts, snappy.WithProgress(0, func(p snappy.UploadProgress) {
+			ps = append(ps, p)
+		}))
+		if err := c.UploadProgramFrom(ctx, v.name+".nc", v.r, opts...); err != nil {
+			t.Fatalf("%s: UploadProgramFrom failed: %v", v.name, err)
+		}
+		if len(ps) < 2 {
+			t.Fatalf("%s: got %d progress reports", v.name, len(ps))
+		}
+		for i, p := range ps {
+			if p.Total != v.total || (i > 0 && p.Sent < ps[i-1].Sent) || p.Rate < 0 {
+				t.Errorf("%s: progress %d: %+v", v.name, i, p)
+			}
+		}
+		if p := ps[len(ps)-1]; p.Sent != int64(len(data)) || p.ETA != 0 {
+			t.Errorf("%s: final progress %+v, want %d sent", v.name, p, len(data))
+		}
+		// Only an upload of unknown size is sent chunked.
+		if p, _ := s.Program(); (p.Length == -1) != (v.total == 0) {
+			t.Errorf("%s: got upload length %d", v.name, p.Length)
+		}
+	}
+
+	var last snappy.UploadProgress
+	if err := c.RunProgramContext(ctx, "job.nc", []byte(data), snappy.WithProgress(time.Hour, func(p snappy.UploadProgress) {
+		last = p
+	})); err != nil {
+		t.Fatalf("RunProgramContext failed: %v", err)
+	}
+	if last.Sent != int64(len(data)) || last.Total != last.Sent {
+		t.Errorf("RunProgramContext final progress %+v", last)
+	}
+	if p, _ := s.Program(); string(p.Data) != data {
+		t.Errorf("got program of %d bytes, want %d", len(p.Data), len(data))
+	}
+}
+
+func TestUploadCancel(t *testing.T) {
+	ctx, s, c := connect(t)
+	home(t, ctx, c)
+	if err := c.UploadProgram(ctx, "old.nc", []byte("G0 X1\n")); err != nil {
+		t.Fatalf("UploadProgram failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	reports := 0
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	data := strings.Repeat("G1 X10 Y10\n", 1<<16)
+	err := c.UploadProgramFrom(cctx, "new.nc", strings.NewReader(data), snappy.WithProgress(0, func(p snappy.UploadProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports++
+		cancel()
+	}))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled upload got %v, want %v", err, context.Canceled)
+	}
+	mu.Lock()
+	n := reports
+	mu.Unlock()
+	if n != 1 {
+		t.Errorf("got %d progress reports, want 1", n)
+	}
+	if p, _ := s.Program(); p.Name != "old.nc" {
+		t.Errorf("canceled upload replaced the program with %q", p.Name)
+	}
+}